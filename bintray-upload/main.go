@@ -30,6 +30,8 @@ var (
 	publish        bool
 	override       bool
 	explode        bool
+
+	manifestFile string
 )
 
 const BINTRAY_API_PREFIX = "https://api.bintray.com/"
@@ -41,7 +43,7 @@ func main() {
 	app.Name = "bintray-upload"
 	app.Usage = "Upload files into bintray repo"
 	app.Authors = "Guoqiang Chen <subchen@gmail.com>"
-	app.UsageText = " [OPTIONS...] target-location source-file..."
+	app.UsageText = " [OPTIONS...] target-location [source-file...]"
 
 	app.Flags = []*cli.Flag{
 		{
@@ -74,10 +76,15 @@ func main() {
 			Value:    &explode,
 			DefValue: "false",
 		},
+		{
+			Name:  "manifest",
+			Usage: "upload the artifacts in manifest.json of go-build",
+			Value: &manifestFile,
+		},
 	}
 
 	app.Action = func(c *cli.Context) {
-		if c.NArg() < 2 {
+		if c.NArg() < 2 && (c.NArg() == 0 || manifestFile == "") {
 			c.ShowHelpAndExit(0)
 		}
 
@@ -94,12 +101,20 @@ func main() {
 		}
 
 		sourceFiles := c.Args()[1:]
+		if manifestFile != "" {
+			files, err := readManifest(manifestFile)
+			runs.PanicIfErr(err)
+			sourceFiles = append(files, sourceFiles...)
+		}
 		for _, f := range sourceFiles {
 			if fs.IsDir(f) {
 				files, err := ioutil.ReadDir(f)
 				runs.PanicIfErr(err)
 
 				for _, file := range files {
+					if file.Name() == goBuildStateFile {
+						continue
+					}
 					bintrayUploadFile(filepath.Join(f, file.Name()))
 				}
 			} else if fs.IsFile(f) {
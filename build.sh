@@ -2,7 +2,7 @@
 
 CWD=$(cd $(dirname $0); pwd)
 
-VERSION=0.1.0
+VERSION=$(cd $CWD/go-build && go run *.go version $CWD)
 
 COMPONENTS="
     go-build
@@ -27,6 +27,8 @@ var (
 	repo     string
 	tag      string
 	override bool
+
+	manifestFile string
 )
 
 // https://developer.github.com/v3/repos/releases/#get-a-release-by-tag-name
@@ -79,10 +81,15 @@ func main() {
 			Value:    &override,
 			DefValue: "false",
 		},
+		{
+			Name:  "manifest",
+			Usage: "upload the artifacts in manifest.json of go-build",
+			Value: &manifestFile,
+		},
 	}
 
 	app.Action = func(c *cli.Context) {
-		if c.NArg() == 0 {
+		if c.NArg() == 0 && manifestFile == "" {
 			c.ShowHelpAndExit(0)
 		}
 
@@ -99,12 +106,20 @@ func main() {
 		release := getRepositoryReleaseByTag(repo, tag)
 
 		sourceFiles := c.Args()
+		if manifestFile != "" {
+			files, err := readManifest(manifestFile)
+			runs.PanicIfErr(err)
+			sourceFiles = append(files, sourceFiles...)
+		}
 		for _, f := range sourceFiles {
 			if fs.IsDir(f) {
 				files, err := ioutil.ReadDir(f)
 				runs.PanicIfErr(err)
 
 				for _, file := range files {
+					if file.Name() == goBuildStateFile {
+						continue
+					}
 					release.uploadAsset(filepath.Join(f, file.Name()))
 				}
 			} else if fs.IsFile(f) {
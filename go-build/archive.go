@@ -0,0 +1,214 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// archiveWriter is an archive, or a linux package, files from disk can be written to
+type archiveWriter interface {
+	Add(name, path string) error
+	AddDir(name, path string) error
+	Close() error
+}
+
+// archiveFormats are the supported values of --archive
+var archiveFormats = []string{"zip", "tar.gz", "deb", "rpm"}
+
+// isArchiveFormat returns true if format is one of archiveFormats
+func isArchiveFormat(format string) bool {
+	for _, f := range archiveFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// newArchive creates a zip archive if the extension of filename is .zip, otherwise a tar.gz.
+// If modTime is not zero, all entries have the modTime, root owner and normalized
+// permissions, so that the same files always produce the same archive.
+func newArchive(filename string, modTime time.Time) (archiveWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(filename) == ".zip" {
+		return &zipArchive{f: f, z: zip.NewWriter(f), modTime: modTime}, nil
+	}
+	gw := gzip.NewWriter(f)
+	return &tarArchive{f: f, gw: gw, tw: tar.NewWriter(gw), modTime: modTime}, nil
+}
+
+// tarArchive is a tar.gz archive
+type tarArchive struct {
+	f  *os.File
+	gw *gzip.Writer
+	tw *tar.Writer
+
+	modTime time.Time // overrides entries mtime if not zero
+}
+
+// Add a file to the tar.gz archive
+func (a *tarArchive) Add(name, path string) error {
+	file, stat, err := openArchiveFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := new(tar.Header)
+	header.Name = name
+	header.Size = stat.Size()
+	header.Mode = int64(stat.Mode())
+	header.ModTime = stat.ModTime()
+	if !a.modTime.IsZero() {
+		header.Mode = int64(reproducibleMode(stat.Mode()))
+		header.ModTime = a.modTime
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(a.tw, file)
+	return err
+}
+
+// AddDir adds a dir and all files in it to the tar.gz archive
+func (a *tarArchive) AddDir(name, path string) error {
+	return walkArchiveDir(name, path, a.Add, func(entryName string, info os.FileInfo) error {
+		header := new(tar.Header)
+		header.Name = entryName + "/"
+		header.Typeflag = tar.TypeDir
+		header.Mode = int64(info.Mode().Perm())
+		header.ModTime = info.ModTime()
+		if !a.modTime.IsZero() {
+			header.Mode = int64(reproducibleMode(info.Mode()))
+			header.ModTime = a.modTime
+		}
+		return a.tw.WriteHeader(header)
+	})
+}
+
+// Close all closeables
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		a.f.Close()
+		return err
+	}
+	if err := a.gw.Close(); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
+
+// zipArchive is a zip archive
+type zipArchive struct {
+	f *os.File
+	z *zip.Writer
+
+	modTime time.Time // overrides entries mtime if not zero
+}
+
+// Add a file to the zip archive
+func (a *zipArchive) Add(name, path string) error {
+	file, stat, err := openArchiveFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header, err := zip.FileInfoHeader(stat)
+	if err != nil {
+		return err
+	}
+	header.Method = zip.Deflate
+	header.Name = name
+	if !a.modTime.IsZero() {
+		header.SetMode(reproducibleMode(stat.Mode()))
+		header.Modified = a.modTime
+	}
+	w, err := a.z.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// AddDir adds a dir and all files in it to the zip archive
+func (a *zipArchive) AddDir(name, path string) error {
+	return walkArchiveDir(name, path, a.Add, func(entryName string, info os.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Method = zip.Store
+		header.Name = entryName + "/"
+		if !a.modTime.IsZero() {
+			header.SetMode(os.ModeDir | reproducibleMode(info.Mode()))
+			header.Modified = a.modTime
+		}
+		_, err = a.z.CreateHeader(header)
+		return err
+	})
+}
+
+// Close all closeables
+func (a *zipArchive) Close() error {
+	if err := a.z.Close(); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
+
+// openArchiveFile opens a regular file to add into archive
+func openArchiveFile(path string) (*os.File, os.FileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if stat.IsDir() {
+		file.Close()
+		return nil, nil, errors.New("unable to add dir into archive: " + path)
+	}
+	return file, stat, nil
+}
+
+// walkArchiveDir calls addFile for files and addDir for dirs in path, with entry names under name
+func walkArchiveDir(name, path string, addFile func(name, path string) error, addDir func(name string, info os.FileInfo) error) error {
+	return filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		entryName := filepath.ToSlash(filepath.Join(name, rel))
+		if !info.IsDir() {
+			return addFile(entryName, file)
+		}
+		return addDir(entryName, info)
+	})
+}
+
+// reproducibleMode returns 0755 for dirs and executables, otherwise 0644
+func reproducibleMode(mode os.FileMode) os.FileMode {
+	if mode.IsDir() || mode&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
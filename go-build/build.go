@@ -0,0 +1,508 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/subchen/go-stack/fs"
+	"github.com/subchen/go-stack/runs"
+)
+
+// buildOptions are the settings shared by all targets
+type buildOptions struct {
+	outputDir string
+	modTime   time.Time // fixed archive entries mtime for reproducible builds
+	buildTime time.Time
+	trimpath  bool
+	noVCS     bool // -buildvcs=false, the vcs.modified stamp changes with untracked files in source tree
+	packages  []mainPackage
+	ldflags   []string
+	vars      []ldflagVar
+	data      ldflagData
+	includes  []includeFile
+	names     *nameTemplate
+	goVersion string
+	state     *buildState // nil to disable incremental builds
+
+	beforeEach hook
+	afterEach  hook
+}
+
+// buildResult is the outcome of building a target
+type buildResult struct {
+	target    target
+	artifacts []artifact
+	err       error
+}
+
+// outputLock serializes the prefixed output of concurrent builds
+var outputLock sync.Mutex
+
+// gobuild builds all targets, returns a usageError for invalid flags,
+// or a buildError if any target failed.
+func gobuild() ([]buildResult, error) {
+	vars, err := parseLdflagVars(ldflagVars)
+	if err != nil {
+		return nil, asUsageError(err)
+	}
+
+	names, err := parseNameTemplate(nameTmpl, nameReplaces)
+	if err != nil {
+		return nil, asUsageError(err)
+	}
+
+	gitRev, err := gitOutput(sourceDir, "rev-list", "HEAD", "--count")
+	if err != nil {
+		return nil, err
+	}
+	gitCommit, err := gitOutput(sourceDir, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	gitTag, _ := gitOutput(sourceDir, "describe", "--tags", "--abbrev=0")
+
+	buildTime := time.Now()
+	if reproducible || verifyRepro {
+		buildTime, err = sourceDateEpoch()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data := ldflagData{
+		Name:      appName,
+		Version:   appVersion,
+		GitCommit: gitCommit,
+		GitRev:    gitRev,
+		GitTag:    gitTag,
+		Date:      buildTime.Format(time.RFC1123Z),
+	}
+
+	flags := []string{"-s", "-w"}
+	if ldflags != "" {
+		flags = append(flags, ldflags)
+	}
+
+	targets, err := resolveTargets(targetSpecs, ignoreSpecs)
+	if err != nil {
+		return nil, asUsageError(err)
+	}
+	if err := validateTargets(targets); err != nil {
+		return nil, asUsageError(err)
+	}
+
+	packages, err := resolvePackages(packagePatterns)
+	if err != nil {
+		return nil, asUsageError(err)
+	}
+
+	includes, err := resolveIncludes(includePatterns)
+	if err != nil {
+		return nil, asUsageError(err)
+	}
+
+	if err := checkSBOMFormats(splitList(sbomFormats)); err != nil {
+		return nil, asUsageError(err)
+	}
+
+	opts := buildOptions{
+		outputDir: outputDir,
+		buildTime: buildTime,
+		packages:  packages,
+		ldflags:   flags,
+		vars:      vars,
+		data:      data,
+		includes:  includes,
+		names:     names,
+
+		beforeEach: hook{"before-each", beforeEachHooks},
+		afterEach:  hook{"after-each", afterEachHooks},
+	}
+	if reproducible || verifyRepro {
+		opts.modTime = buildTime
+		opts.trimpath = true
+		opts.noVCS = true
+	}
+
+	opts.goVersion, _, err = newCommand("go", "env", "GOVERSION").Output()
+	if err != nil {
+		return nil, err
+	}
+	opts.state, err = loadBuildState(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkArtifactNames(targets, opts); err != nil {
+		return nil, asUsageError(err)
+	}
+
+	err = hook{"before-all", beforeAllHooks}.run(hookEnv(outputDir), os.Stdout, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	results := buildTargets(targets, opts)
+
+	if err := opts.state.save(); err != nil {
+		return results, err
+	}
+	if interrupted.Err() != nil {
+		return results, errInterrupted
+	}
+
+	filename, err := writeManifest(results)
+	if err != nil {
+		return results, err
+	}
+	fmt.Printf("manifest: %s\n", filename)
+
+	if err := printSummary(results); err != nil {
+		return results, err
+	}
+
+	if verifyRepro {
+		if err := verifyReproducible(targets, opts); err != nil {
+			return results, err
+		}
+	}
+
+	env := append(hookEnv(outputDir), "BUILD_MANIFEST="+filename)
+	err = hook{"after-all", afterAllHooks}.run(env, os.Stdout, os.Stderr)
+	if err != nil {
+		return results, err
+	}
+
+	fmt.Println("go build: Completed!")
+	return results, nil
+}
+
+// buildTargets builds all targets using a pool of --parallel workers,
+// the results are returned in the same order as targets.
+func buildTargets(targets []target, opts buildOptions) []buildResult {
+	workers := parallel
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(targets) {
+		workers = len(targets)
+	}
+
+	results := make([]buildResult, len(targets))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				t := targets[j]
+				if interrupted.Err() != nil {
+					results[j] = buildResult{t, nil, errInterrupted}
+					continue
+				}
+				if workers == 1 {
+					artifacts, err := buildTarget(t, opts, os.Stdout, os.Stderr)
+					results[j] = buildResult{t, artifacts, err}
+					continue
+				}
+
+				prefix := "[" + t.String() + "] "
+				stdout := &prefixWriter{lock: &outputLock, w: os.Stdout, prefix: prefix}
+				stderr := &prefixWriter{lock: &outputLock, w: os.Stderr, prefix: prefix}
+				artifacts, err := buildTarget(t, opts, stdout, stderr)
+				results[j] = buildResult{t, artifacts, err}
+				stdout.Flush()
+				stderr.Flush()
+			}
+		}()
+	}
+
+	for i := range targets {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// binaryFile is a compiled binary of a target
+type binaryFile struct {
+	name     string // entry name in archive
+	path     string // path on disk
+	pkgName  string
+	basename string   // file name without extension
+	sboms    []string // sbom files in output-dir
+}
+
+// buildTarget compiles and archives all packages of a single target,
+// returns the artifacts written into output-dir. If any package failed,
+// the files written for the other packages are removed, so that the output-dir
+// has no partial target to be released.
+func buildTarget(t target, opts buildOptions, stdout, stderr io.Writer) (artifacts []artifact, err error) {
+	fingerprint := ""
+	var written []string
+	defer func() {
+		if r := recover(); r != nil {
+			err = runs.AsError(r)
+		}
+		if err != nil {
+			for _, file := range written {
+				os.Remove(file)
+			}
+		}
+		if err == nil && fingerprint != "" {
+			opts.state.update(t, fingerprint, artifacts)
+		}
+	}()
+
+	data := opts.data
+	data.GOOS = t.goos
+	data.GOARCH = t.goarch
+	var verifyVars []string
+	ldflags := append([]string(nil), opts.ldflags...)
+	for _, v := range opts.vars {
+		value, err := v.render(data)
+		if err != nil {
+			return nil, err
+		}
+		if v.verify {
+			verifyVars = append(verifyVars, v.name)
+		}
+		flag, err := ldflagX(v.name, value)
+		if err != nil {
+			return nil, err
+		}
+		ldflags = append(ldflags, flag)
+	}
+
+	settings, err := targetSettings(t)
+	if err != nil {
+		return nil, err
+	}
+	format := settings.archive
+	ext := binaryExt(t, settings.buildmode)
+
+	hookEnv := append(targetHookEnv(t, opts.outputDir, nil), settings.env...)
+	if err := opts.beforeEach.run(hookEnv, stdout, stderr); err != nil {
+		return nil, err
+	}
+
+	// fingerprint after before-each hooks, which may generate sources
+	if opts.state != nil {
+		fingerprint, err = targetFingerprint(t, settings, opts)
+		if err != nil {
+			return nil, err
+		}
+		if cached, ok := opts.state.lookup(t, fingerprint); ok && !force {
+			fmt.Fprintf(stdout, "go build: %s is up to date, skipped\n", t)
+			return cached, nil
+		}
+	}
+
+	var binaries []binaryFile
+	for _, pkg := range opts.packages {
+		compile := func(t target, output string) error {
+			return newCommand("go", settings.args(opts, ldflags, output, pkg.importPath)...).
+				Dir(sourceDir).
+				Env(append(t.env(), settings.env...)...).
+				Stdout(stdout).
+				Stderr(stderr).
+				Run()
+		}
+
+		basename, err := opts.names.render(pkg.name, t, "")
+		if err != nil {
+			return nil, err
+		}
+		filename, err := opts.names.render(pkg.name, t, ext)
+		if err != nil {
+			return nil, err
+		}
+		outputFilename := filepath.Join(opts.outputDir, filename)
+
+		fmt.Fprintf(stdout, "go build: %s ...\n", outputFilename)
+		written = append(written, outputFilename)
+		res := newWinResource(t, pkg.name, filename)
+		err = lockPackageDir(pkg.dir, res != nil, func() error {
+			if res != nil {
+				syso, err := res.writeSyso(pkg.dir, t)
+				defer removeSyso(syso)
+				if err != nil {
+					return err
+				}
+			}
+			if t.goarch == universalArch {
+				return buildUniversal(t, outputFilename, compile)
+			}
+			return compile(t, outputFilename)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		missing, err := verifyLdflagVars(pkg, settings, append(t.archs()[0].env(), settings.env...), verifyVars)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range missing {
+			fmt.Fprintf(stderr, "warning: -X %s is not set in %s, no such string var declared\n", name, pkg.name)
+		}
+
+		sboms, err := writeSBOMs(outputFilename, basename, pkg, t, settings, opts)
+		written = append(written, sboms...)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range sboms {
+			fmt.Fprintf(stdout, "sbom: %s\n", file)
+		}
+
+		binaries = append(binaries, binaryFile{
+			name:     pkg.name + ext,
+			path:     outputFilename,
+			pkgName:  pkg.name,
+			basename: basename,
+			sboms:    sboms,
+		})
+	}
+
+	paths := make([]string, 0, len(binaries))
+	for _, b := range binaries {
+		paths = append(paths, b.path)
+	}
+	hookEnv = append(targetHookEnv(t, opts.outputDir, paths), settings.env...)
+	if err := opts.afterEach.run(hookEnv, stdout, stderr); err != nil {
+		return nil, err
+	}
+
+	if format == "" {
+		for _, b := range binaries {
+			a, err := newArtifact(b.path, format, t, []binaryFile{b}, data)
+			if err != nil {
+				return artifacts, err
+			}
+			artifacts = append(artifacts, a)
+		}
+		return artifacts, nil
+	}
+
+	// group binaries by the archive to be written
+	type output struct {
+		name     string
+		basename string
+		binaries []binaryFile
+	}
+	var outputs []output
+	if bundle {
+		basename, err := opts.names.render(appName, t, "")
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, output{appName, basename, binaries})
+	} else {
+		for _, b := range binaries {
+			outputs = append(outputs, output{b.pkgName, b.basename, []binaryFile{b}})
+		}
+	}
+
+	for _, o := range outputs {
+		filename, err := opts.names.render(o.name, t, "."+format)
+		if err != nil {
+			return artifacts, err
+		}
+		file := filepath.Join(opts.outputDir, filename)
+		if err := archiveBinaries(file, o.name, o.basename, format, t, o.binaries, opts, stdout); err != nil {
+			return artifacts, err
+		}
+		written = append(written, file)
+
+		a, err := newArtifact(file, format, t, o.binaries, data)
+		if err != nil {
+			return artifacts, err
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, nil
+}
+
+// archiveBinaries archives the binaries with extra files into archiveFilename,
+// name is the package name of deb or rpm, the binaries are removed after archived.
+func archiveBinaries(archiveFilename, name, basename, format string, t target, binaries []binaryFile, opts buildOptions, stdout io.Writer) error {
+	fmt.Fprintf(stdout, "archived: %s ...\n", archiveFilename)
+
+	var a archiveWriter
+	var entryName func(name string, isBinary bool) string
+	if isLinuxPackageFormat(format) {
+		pkg, err := newLinuxPackage(archiveFilename, name, format, t, opts.modTime)
+		if err != nil {
+			return err
+		}
+		a = pkg
+		entryName = func(entry string, isBinary bool) string {
+			return packageEntryName(name, entry, isBinary)
+		}
+	} else {
+		var err error
+		a, err = newArchive(archiveFilename, opts.modTime)
+		if err != nil {
+			return err
+		}
+
+		// put all files into a top-level dir if there are extra files
+		entryPrefix := ""
+		if len(opts.includes) > 0 {
+			entryPrefix = basename + "/"
+		}
+		entryName = func(name string, isBinary bool) string {
+			return entryPrefix + name
+		}
+	}
+
+	// remove the incomplete archive on failure
+	if err := addArchiveFiles(a, binaries, opts.includes, entryName); err != nil {
+		a.Close()
+		os.Remove(archiveFilename)
+		return err
+	}
+	if err := a.Close(); err != nil {
+		os.Remove(archiveFilename)
+		return err
+	}
+
+	// remove binaries
+	for _, b := range binaries {
+		if err := os.Remove(b.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addArchiveFiles adds the binaries and extra files into archive
+func addArchiveFiles(a archiveWriter, binaries []binaryFile, includes []includeFile, entryName func(string, bool) string) error {
+	for _, b := range binaries {
+		if err := a.Add(entryName(b.name, true), b.path); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range includes {
+		var err error
+		if fs.IsDir(f.path) {
+			err = a.AddDir(entryName(f.name, false), f.path)
+		} else {
+			err = a.Add(entryName(f.name, false), f.path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
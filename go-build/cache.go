@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	sha256sum "github.com/subchen/go-stack/encoding/sha256"
+	"github.com/subchen/go-stack/fs"
+)
+
+// stateFile records the fingerprints of built targets in output-dir
+const stateFile = ".go-build-state.json"
+
+// buildState is the content of state file
+type buildState struct {
+	lock    sync.Mutex
+	file    string
+	Targets map[string]targetState `json:"targets"`
+}
+
+// targetState is the fingerprint and artifacts of the last build of a target
+type targetState struct {
+	Fingerprint string     `json:"fingerprint"`
+	Artifacts   []artifact `json:"artifacts"`
+}
+
+// loadBuildState reads the state file in dir, returns an empty state if not exists
+func loadBuildState(dir string) (*buildState, error) {
+	s := &buildState{
+		file:    filepath.Join(dir, stateFile),
+		Targets: map[string]targetState{},
+	}
+	if !fs.IsFile(s.file) {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v, remove it or use --force", s.file, err)
+	}
+	if s.Targets == nil {
+		s.Targets = map[string]targetState{}
+	}
+	return s, nil
+}
+
+// lookup returns the artifacts of target if its fingerprint is not changed
+// and all artifacts are not modified in output-dir.
+func (s *buildState) lookup(t target, fingerprint string) ([]artifact, bool) {
+	s.lock.Lock()
+	ts, ok := s.Targets[t.String()]
+	s.lock.Unlock()
+
+	if !ok || ts.Fingerprint != fingerprint || len(ts.Artifacts) == 0 {
+		return nil, false
+	}
+	dir := filepath.Dir(s.file)
+	for _, a := range ts.Artifacts {
+		sum, err := sha256sum.SumFile(filepath.Join(dir, a.Path))
+		if err != nil || sum != a.SHA256 {
+			return nil, false
+		}
+		for _, file := range a.SBOMs {
+			if !fs.IsFile(filepath.Join(dir, file)) {
+				return nil, false
+			}
+		}
+	}
+	return ts.Artifacts, true
+}
+
+// update records the fingerprint and artifacts of target
+func (s *buildState) update(t target, fingerprint string, artifacts []artifact) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Targets[t.String()] = targetState{Fingerprint: fingerprint, Artifacts: artifacts}
+}
+
+// save writes the state file
+func (s *buildState) save() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fs.FileWriteBytes(s.file, append(data, '\n'))
+}
+
+// targetFingerprint returns the hash of everything the artifacts of target depend on:
+// go version, env, go build flags, sources of all dependencies, extra files and settings.
+// The build date is excluded, so that a target is skipped if nothing else changed.
+func targetFingerprint(t target, settings goSettings, opts buildOptions) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "go %s\n", opts.goVersion)
+	fmt.Fprintf(h, "target %s\n", t)
+	for _, e := range settings.env {
+		fmt.Fprintf(h, "env %s\n", e)
+	}
+
+	data := opts.data
+	data.GOOS = t.goos
+	data.GOARCH = t.goarch
+	data.Date = ""
+	ldflags := append([]string(nil), opts.ldflags...)
+	for _, v := range opts.vars {
+		value, err := v.render(data)
+		if err != nil {
+			return "", err
+		}
+		ldflags = append(ldflags, v.name+"="+value)
+	}
+	for _, pkg := range opts.packages {
+		filename, err := opts.names.render(pkg.name, t, binaryExt(t, settings.buildmode))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "build %q\n", settings.args(opts, ldflags, filename, pkg.importPath))
+	}
+
+	fmt.Fprintf(h, "archive %s %s bundle=%v\n", settings.archive, opts.names.text, bundle)
+	fmt.Fprintf(h, "names %q\n", nameReplaces)
+	fmt.Fprintf(h, "hooks %q %q\n", opts.beforeEach.commands, opts.afterEach.commands)
+	fmt.Fprintf(h, "sbom %q\n", splitList(sbomFormats))
+	for _, f := range opts.includes {
+		fmt.Fprintf(h, "include %s\n", f.name)
+		if err := hashFiles(h, f.path); err != nil {
+			return "", err
+		}
+	}
+
+	if winRes && t.goos == "windows" {
+		fmt.Fprintf(h, "win %q\n", []string{winCompany, winDescription, winCopyright, winIcon})
+		if winIcon != "" {
+			if err := hashFiles(h, filepath.Join(sourceDir, winIcon)); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if isLinuxPackageFormat(settings.archive) {
+		fmt.Fprintf(h, "pkg %q\n", []string{pkgMaintainer, pkgDescription, pkgInstallDir, strings.Join(pkgDepends, ",")})
+		for _, file := range []string{pkgSystemdUnit, pkgPreinstall, pkgPostinstall, pkgPreremove, pkgPostremove} {
+			fmt.Fprintf(h, "pkg file %s\n", file)
+			if file != "" {
+				if err := hashFiles(h, filepath.Join(sourceDir, file)); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+
+	for _, arch := range t.archs() {
+		env := append(arch.env(), settings.env...)
+		if err := hashSources(h, settings, env, opts.packages); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// listedPackage is a package printed by `go list -json`
+type listedPackage struct {
+	ImportPath string
+	Name       string
+	Dir        string
+	Standard   bool
+	GoFiles    []string
+	CgoFiles   []string
+	CFiles     []string
+	CXXFiles   []string
+	MFiles     []string
+	HFiles     []string
+	FFiles     []string
+	SFiles     []string
+	SysoFiles  []string
+	EmbedFiles []string
+}
+
+// hashSources writes the source files of packages and all non-standard dependencies into h,
+// the standard packages are covered by go version.
+func hashSources(h io.Writer, settings goSettings, env []string, packages []mainPackage) error {
+	args := []string{"list", "-deps", "-json"}
+	if len(settings.tags) > 0 {
+		args = append(args, "-tags", strings.Join(settings.tags, ","))
+	}
+	for _, pkg := range packages {
+		if pkg.importPath == "" {
+			args = append(args, ".")
+		} else {
+			args = append(args, pkg.importPath)
+		}
+	}
+
+	out, _, err := newCommand("go", args...).Dir(sourceDir).Env(env...).Output()
+	if err != nil {
+		return fmt.Errorf("unable to list dependencies: %v", err)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(out))
+	for dec.More() {
+		var p listedPackage
+		if err := dec.Decode(&p); err != nil {
+			return fmt.Errorf("unable to list dependencies: %v", err)
+		}
+		if p.Standard {
+			continue
+		}
+
+		fmt.Fprintf(h, "package %s\n", p.ImportPath)
+		var files []string
+		for _, list := range [][]string{p.GoFiles, p.CgoFiles, p.CFiles, p.CXXFiles, p.MFiles, p.HFiles, p.FFiles, p.SFiles, p.SysoFiles, p.EmbedFiles} {
+			files = append(files, list...)
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			fmt.Fprintf(h, "file %s\n", file)
+			if err := copyFile(h, filepath.Join(p.Dir, file)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hashFiles writes the content of a file, or all files in a dir into h
+func hashFiles(h io.Writer, path string) error {
+	return filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "file %s %o\n", filepath.ToSlash(rel), info.Mode().Perm())
+		return copyFile(h, file)
+	})
+}
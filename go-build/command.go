@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// interrupted is done when SIGINT or SIGTERM received, the running commands are killed,
+// so that the builds fail and clean up by their defers, a second signal exits at once.
+var interrupted = notifyInterrupt()
+
+func notifyInterrupt() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		fmt.Fprintf(os.Stderr, "go build: %v\n", sig)
+		cancel()
+	}()
+	return ctx
+}
+
+// command is a builder to run an external command without shell,
+// the args are passed as is, so that no quoting is required.
+type command struct {
+	name    string
+	args    []string
+	dir     string
+	env     []string
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	ctx     context.Context
+	timeout time.Duration
+	group   bool // run in a new process group
+}
+
+// newCommand returns a command to run name with args, killed if interrupted
+func newCommand(name string, arg ...string) *command {
+	return &command{
+		name:   name,
+		args:   arg,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		ctx:    interrupted,
+	}
+}
+
+// Dir sets the working dir of command
+func (c *command) Dir(dir string) *command {
+	c.dir = dir
+	return c
+}
+
+// Env adds env vars in the form of name=value, overlaid on the env of current process
+func (c *command) Env(env ...string) *command {
+	c.env = append(c.env, env...)
+	return c
+}
+
+// Stdin sets the stdin of command
+func (c *command) Stdin(r io.Reader) *command {
+	c.stdin = r
+	return c
+}
+
+// Stdout sets the stdout of command, default is os.Stdout
+func (c *command) Stdout(w io.Writer) *command {
+	c.stdout = w
+	return c
+}
+
+// Stderr sets the stderr of command, default is os.Stderr
+func (c *command) Stderr(w io.Writer) *command {
+	c.stderr = w
+	return c
+}
+
+// Context sets the context to kill the command when done
+func (c *command) Context(ctx context.Context) *command {
+	c.ctx = ctx
+	return c
+}
+
+// ProcessGroup runs the command in a new process group, so that the processes
+// started by it, e.g. the children of a shell, are killed with it when context is done
+func (c *command) ProcessGroup() *command {
+	c.group = true
+	return c
+}
+
+// Timeout sets the max duration of command, zero is no timeout
+func (c *command) Timeout(timeout time.Duration) *command {
+	c.timeout = timeout
+	return c
+}
+
+// String returns the command line for logging
+func (c *command) String() string {
+	return strings.Join(append([]string{c.name}, c.args...), " ")
+}
+
+// Run runs the command and waits for it to complete
+func (c *command) Run() error {
+	ctx := c.ctx
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, c.name, c.args...)
+	cmd.Dir = c.dir
+	if len(c.env) > 0 {
+		cmd.Env = mergeEnv(os.Environ(), c.env)
+	}
+	cmd.Stdin = c.stdin
+	cmd.Stdout = c.stdout
+	cmd.Stderr = c.stderr
+	if c.group {
+		setProcessGroup(cmd)
+	}
+
+	err := cmd.Run()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s: timed out after %s", c.name, c.timeout)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s: %v", c.name, ctx.Err())
+		}
+		return fmt.Errorf("%s: %v", c.name, err)
+	}
+	return nil
+}
+
+// Output runs the command and returns its stdout and stderr separately
+func (c *command) Output() (stdout string, stderr string, err error) {
+	var outbuf, errbuf bytes.Buffer
+	c.stdout = &outbuf
+	c.stderr = &errbuf
+	err = c.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(errbuf.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+	}
+	return outbuf.String(), errbuf.String(), err
+}
+
+// mergeEnv returns env with overlay, a var in overlay replaces the one with same name
+func mergeEnv(env, overlay []string) []string {
+	index := map[string]int{}
+	merged := make([]string, 0, len(env)+len(overlay))
+	for _, kv := range append(env, overlay...) {
+		name := kv
+		if i := strings.Index(kv, "="); i >= 0 {
+			name = kv[:i]
+		}
+		if i, ok := index[name]; ok {
+			merged[i] = kv
+		} else {
+			index[name] = len(merged)
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/subchen/go-cli"
+	"github.com/subchen/go-stack/fs"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultConfigFile is loaded from source-dir if no --config provided
+const defaultConfigFile = "go-build.yaml"
+
+// buildConfig is the content of go-build.yaml
+//
+// The top-level keys mirror the long names of command line flags,
+// and per-target overrides are declared under `targets` as `goos/goarch[/variant]`:
+//
+//	app-name: myapp
+//	goos: [linux, darwin, windows]
+//	goarch: [amd64, arm64]
+//	archive: tar.gz
+//	ldflags: -extldflags -static
+//	tags: netgo,osusergo
+//	targets:
+//	  linux/amd64:
+//	    env: [CGO_ENABLED=0]
+//	  windows/amd64:
+//	    archive: zip
+type buildConfig struct {
+	Targets map[string]targetConfig `yaml:"targets"`
+	Flags   map[string]configValue  `yaml:",inline"`
+}
+
+// targetConfig overrides the global settings for a target
+type targetConfig struct {
+	Archive   string      `yaml:"archive"`
+	Env       configValue `yaml:"env"`
+	Tags      configValue `yaml:"tags"`
+	Gcflags   string      `yaml:"gcflags"`
+	Asmflags  string      `yaml:"asmflags"`
+	Ldflags   string      `yaml:"ldflags"`
+	Buildmode string      `yaml:"buildmode"`
+}
+
+// targetConfigs holds the per-target overrides, keyed by `goos/goarch[/variant]`
+var targetConfigs = map[string]targetConfig{}
+
+// loadConfig reads the config file and applies its values to the flags
+// which are not set on the command line.
+func loadConfig(c *cli.Context, flags []*cli.Flag, filename string) error {
+	if filename == "" {
+		filename = filepath.Join(sourceDir, defaultConfigFile)
+		if !fs.IsFile(filename) {
+			return nil
+		}
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	config := new(buildConfig)
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", filename, err)
+	}
+
+	for key, value := range config.Flags {
+		f := lookupConfigFlag(flags, key)
+		if f == nil {
+			return fmt.Errorf("invalid config file %s: unknown key '%s'", filename, key)
+		}
+		if c.IsSet(key) {
+			continue // command line wins
+		}
+
+		values := []string(value)
+		if _, ok := f.Value.(*[]string); ok {
+			for _, v := range values {
+				if err := f.SetValue(v); err != nil {
+					return fmt.Errorf("invalid config file %s: %s: %v", filename, key, err)
+				}
+			}
+		} else if err := f.SetValue(strings.Join(values, ",")); err != nil {
+			return fmt.Errorf("invalid config file %s: %s: %v", filename, key, err)
+		}
+	}
+
+	for name, tc := range config.Targets {
+		t, err := parseTarget(name)
+		if err != nil {
+			return fmt.Errorf("invalid config file %s: %v", filename, err)
+		}
+		if tc.Archive != "" && !isArchiveFormat(tc.Archive) {
+			return fmt.Errorf("invalid config file %s: targets: %s: invalid archive %s, should be one of: %s", filename, name, tc.Archive, strings.Join(archiveFormats, ", "))
+		}
+		targetConfigs[t.String()] = tc
+	}
+
+	return nil
+}
+
+// lookupConfigFlag finds the flag by its long name
+func lookupConfigFlag(flags []*cli.Flag, key string) *cli.Flag {
+	if key == "config" {
+		return nil
+	}
+	for _, f := range flags {
+		names := f.Names()
+		if names[len(names)-1] == key {
+			return f
+		}
+	}
+	return nil
+}
+
+// configValue is a yaml scalar or sequence, kept as written
+// so that a version like `1.10` is not read as a float.
+type configValue []string
+
+func (v *configValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err == nil {
+		*v = configValue{value}
+		return nil
+	}
+	var values []string
+	if err := unmarshal(&values); err != nil {
+		return err
+	}
+	*v = configValue(values)
+	return nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// debArchive writes the package in debian binary format
+type debArchive struct {
+	*linuxPackage
+}
+
+// debArchs maps GOARCH to debian architecture
+var debArchs = map[string]string{
+	"386":      "i386",
+	"amd64":    "amd64",
+	"arm":      "armhf",
+	"arm/v5":   "armel",
+	"arm/v6":   "armel",
+	"arm64":    "arm64",
+	"mips":     "mips",
+	"mipsle":   "mipsel",
+	"mips64le": "mips64el",
+	"ppc64le":  "ppc64el",
+	"s390x":    "s390x",
+}
+
+// debScripts maps the script names to maintainer scripts
+var debScripts = map[string]string{
+	"preinstall":  "preinst",
+	"postinstall": "postinst",
+	"preremove":   "prerm",
+	"postremove":  "postrm",
+}
+
+// Close writes the .deb file
+func (a debArchive) Close() error {
+	arch, err := a.arch("deb", debArchs)
+	if err != nil {
+		return err
+	}
+
+	data, md5sums, err := a.dataTarGz()
+	if err != nil {
+		return err
+	}
+	control, err := a.controlTarGz(arch, md5sums)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(a.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.WriteString(f, "!<arch>\n"); err != nil {
+		return err
+	}
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", control},
+		{"data.tar.gz", data},
+	} {
+		if err := a.writeArMember(f, member.name, member.data); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// writeArMember writes a file into ar archive
+func (a debArchive) writeArMember(w io.Writer, name string, data []byte) error {
+	header := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8s%-10d`\n", name, a.modTime.Unix(), 0, 0, "100644", len(data))
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if len(data)%2 != 0 {
+		_, err := w.Write([]byte{'\n'})
+		return err
+	}
+	return nil
+}
+
+// dataTarGz returns the data.tar.gz and md5sums of files
+func (a debArchive) dataTarGz() ([]byte, string, error) {
+	files := a.sortedFiles()
+
+	// parent dirs of all files
+	dirs := map[string]bool{}
+	for _, f := range files {
+		for dir := path.Dir(f.name); dir != "/"; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	dirnames := []string{"/"}
+	for dir := range dirs {
+		dirnames = append(dirnames, dir)
+	}
+	sort.Strings(dirnames)
+
+	var md5sums bytes.Buffer
+	buf, err := writeTarGz(func(tw *tar.Writer) error {
+		for _, dir := range dirnames {
+			if err := a.writeTarDir(tw, "."+strings.TrimSuffix(dir, "/")+"/"); err != nil {
+				return err
+			}
+		}
+
+		for _, f := range files {
+			header := &tar.Header{
+				Name:    "." + f.name,
+				Mode:    int64(f.mode),
+				Size:    f.size,
+				ModTime: a.modTime,
+				Uname:   "root",
+				Gname:   "root",
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+
+			h := md5.New()
+			if err := copyFile(io.MultiWriter(tw, h), f.path); err != nil {
+				return err
+			}
+			fmt.Fprintf(&md5sums, "%x  %s\n", h.Sum(nil), strings.TrimPrefix(f.name, "/"))
+		}
+		return nil
+	})
+	return buf, md5sums.String(), err
+}
+
+// controlTarGz returns the control.tar.gz
+func (a debArchive) controlTarGz(arch, md5sums string) ([]byte, error) {
+	var control bytes.Buffer
+	fmt.Fprintf(&control, "Package: %s\n", a.name)
+	fmt.Fprintf(&control, "Version: %s\n", a.version)
+	fmt.Fprintf(&control, "Architecture: %s\n", arch)
+	fmt.Fprintf(&control, "Maintainer: %s\n", a.maintainer)
+	fmt.Fprintf(&control, "Installed-Size: %d\n", (a.installedSize()+1023)/1024)
+	if len(a.depends) > 0 {
+		depends := make([]string, 0, len(a.depends))
+		for _, d := range a.depends {
+			depends = append(depends, d.debString())
+		}
+		fmt.Fprintf(&control, "Depends: %s\n", strings.Join(depends, ", "))
+	}
+	fmt.Fprintf(&control, "Section: default\n")
+	fmt.Fprintf(&control, "Priority: optional\n")
+
+	// extended description lines start with a space, empty line as " ."
+	lines := strings.Split(strings.TrimSpace(a.description), "\n")
+	fmt.Fprintf(&control, "Description: %s\n", lines[0])
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			line = "."
+		}
+		fmt.Fprintf(&control, " %s\n", line)
+	}
+
+	var conffiles bytes.Buffer
+	for _, f := range a.sortedFiles() {
+		if f.isConfigFile() {
+			fmt.Fprintln(&conffiles, f.name)
+		}
+	}
+
+	return writeTarGz(func(tw *tar.Writer) error {
+		if err := a.writeTarDir(tw, "./"); err != nil {
+			return err
+		}
+		if err := a.writeTarFile(tw, "./control", 0644, control.String()); err != nil {
+			return err
+		}
+		if err := a.writeTarFile(tw, "./md5sums", 0644, md5sums); err != nil {
+			return err
+		}
+		if conffiles.Len() > 0 {
+			if err := a.writeTarFile(tw, "./conffiles", 0644, conffiles.String()); err != nil {
+				return err
+			}
+		}
+
+		names := make([]string, 0, len(a.scripts))
+		for name := range a.scripts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := a.writeTarFile(tw, "./"+debScripts[name], 0755, a.scripts[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (a debArchive) writeTarDir(tw *tar.Writer, name string) error {
+	return tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+		ModTime:  a.modTime,
+		Uname:    "root",
+		Gname:    "root",
+	})
+}
+
+func (a debArchive) writeTarFile(tw *tar.Writer, name string, mode int64, content string) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    mode,
+		Size:    int64(len(content)),
+		ModTime: a.modTime,
+		Uname:   "root",
+		Gname:   "root",
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.WriteString(tw, content)
+	return err
+}
+
+// writeTarGz returns the tar.gz content written by fn
+func writeTarGz(fn func(tw *tar.Writer) error) ([]byte, error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	if err := fn(tw); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// debString returns the dependency in debian control format
+func (d packageDepend) debString() string {
+	if d.op == "" {
+		return d.name
+	}
+	op := d.op
+	if op == "<" || op == ">" {
+		op += op // strictly less or greater are << and >> in debian
+	}
+	return fmt.Sprintf("%s (%s %s)", d.name, op, d.version)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/subchen/go-cli"
+)
+
+// exit codes
+const (
+	exitFailure = 1 // build failed
+	exitUsage   = 2 // invalid args, flags or config
+)
+
+// errInterrupted is returned if go-build is interrupted by a signal
+var errInterrupted = errors.New("interrupted")
+
+// usageError is an error caused by invalid args, flags or config
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string {
+	return e.err.Error()
+}
+
+// usageErrorf returns a usageError with formatted message
+func usageErrorf(format string, args ...interface{}) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// asUsageError marks err as a usageError, returns nil if err is nil
+func asUsageError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return usageError{err}
+}
+
+// buildError is returned if any target failed to build
+type buildError struct {
+	failed int
+	total  int
+}
+
+func (e buildError) Error() string {
+	return fmt.Sprintf("%d of %d targets failed", e.failed, e.total)
+}
+
+// exitIfErr prints err and exits with exitUsage for usageError, or exitFailure for others
+func exitIfErr(c *cli.Context, err error) {
+	if err == nil {
+		return
+	}
+	if _, ok := err.(usageError); ok {
+		exitUsageError(c.Name(), err)
+	}
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(exitFailure)
+}
+
+// exitUsageError prints err with a hint to run --help of command and exits with exitUsage
+func exitUsageError(command string, err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n\nRun '%s --help' for more information\n", err, command)
+	os.Exit(exitUsage)
+}
+
+// printSummary prints the status and artifacts or error of each target,
+// returns a buildError if any target failed.
+func printSummary(results []buildResult) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tSTATUS\tARTIFACTS")
+
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			msg := strings.Join(strings.Fields(r.err.Error()), " ")
+			fmt.Fprintf(w, "%s\tfailed\t%s\n", r.target, msg)
+			continue
+		}
+		paths := make([]string, 0, len(r.artifacts))
+		for _, a := range r.artifacts {
+			paths = append(paths, a.Path)
+		}
+		fmt.Fprintf(w, "%s\tok\t%s\n", r.target, strings.Join(paths, ", "))
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return buildError{failed, len(results)}
+	}
+	return nil
+}
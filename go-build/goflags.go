@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// goSettings are the go build settings of a target,
+// the global flags merged with the per-target config.
+type goSettings struct {
+	archive   string
+	env       []string
+	tags      []string
+	gcflags   []string
+	asmflags  []string
+	ldflags   []string // in addition to the global -ldflags
+	buildmode string
+}
+
+// targetSettings returns the go build settings of the target,
+// env, tags and flags of target are appended to the global ones,
+// archive and buildmode of target override the global ones.
+func targetSettings(t target) (goSettings, error) {
+	s := goSettings{
+		archive:   archiveFmt,
+		env:       append([]string(nil), buildEnv...),
+		tags:      splitList(buildTags),
+		buildmode: buildmode,
+	}
+	if gcflags != "" {
+		s.gcflags = append(s.gcflags, gcflags)
+	}
+	if asmflags != "" {
+		s.asmflags = append(s.asmflags, asmflags)
+	}
+
+	if tc, ok := lookupTargetConfig(t); ok {
+		if tc.Archive != "" {
+			s.archive = tc.Archive
+		}
+		if tc.Buildmode != "" {
+			s.buildmode = tc.Buildmode
+		}
+		s.env = append(s.env, tc.Env...)
+		s.tags = append(s.tags, splitList(tc.Tags)...)
+		if tc.Gcflags != "" {
+			s.gcflags = append(s.gcflags, tc.Gcflags)
+		}
+		if tc.Asmflags != "" {
+			s.asmflags = append(s.asmflags, tc.Asmflags)
+		}
+		if tc.Ldflags != "" {
+			s.ldflags = append(s.ldflags, tc.Ldflags)
+		}
+	}
+
+	for _, env := range s.env {
+		if strings.Index(env, "=") <= 0 {
+			return s, fmt.Errorf("invalid env %s for %s, should be name=value", env, t)
+		}
+	}
+	return s, nil
+}
+
+// args returns the arguments of `go build` for a package
+func (s goSettings) args(opts buildOptions, ldflags []string, output, importPath string) []string {
+	args := []string{"build"}
+	if opts.trimpath {
+		args = append(args, "-trimpath")
+	}
+	if opts.noVCS {
+		args = append(args, "-buildvcs=false")
+	}
+	if s.buildmode != "" {
+		args = append(args, "-buildmode="+s.buildmode)
+	}
+	if len(s.tags) > 0 {
+		args = append(args, "-tags", strings.Join(s.tags, ","))
+	}
+	if len(s.gcflags) > 0 {
+		args = append(args, "-gcflags", strings.Join(s.gcflags, " "))
+	}
+	if len(s.asmflags) > 0 {
+		args = append(args, "-asmflags", strings.Join(s.asmflags, " "))
+	}
+	args = append(args, "-ldflags", strings.Join(append(ldflags, s.ldflags...), " "))
+	args = append(args, "-o", output)
+	if importPath != "" {
+		args = append(args, importPath)
+	}
+	return args
+}
+
+// binaryExt returns the extension of binaries for the target and buildmode
+func binaryExt(t target, buildmode string) string {
+	switch buildmode {
+	case "c-archive":
+		return ".a"
+	case "c-shared", "plugin":
+		switch t.goos {
+		case "windows":
+			return ".dll"
+		case "darwin":
+			return ".dylib"
+		}
+		return ".so"
+	}
+	if t.goos == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+// splitList returns the non-empty items of comma separated values
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
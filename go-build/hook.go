@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// hook is a shell command run around the builds
+type hook struct {
+	name     string // before-all, before-each, after-each or after-all
+	commands []string
+}
+
+// hookEnv returns the env vars exported to all hooks
+func hookEnv(dir string) []string {
+	return []string{
+		"APP_NAME=" + appName,
+		"APP_VERSION=" + appVersion,
+		"SOURCE_DIR=" + sourceDir,
+		"OUTPUT_DIR=" + dir,
+	}
+}
+
+// targetHookEnv returns the env vars exported to the hooks of a target,
+// BUILD_OUTPUT is the space separated binaries of the target for after-each.
+func targetHookEnv(t target, dir string, outputs []string) []string {
+	env := append(hookEnv(dir), t.env()...)
+	env = append(env, "BUILD_TARGET="+t.String())
+	if outputs != nil {
+		env = append(env, "BUILD_OUTPUT="+strings.Join(outputs, " "))
+	}
+	return env
+}
+
+// run runs the commands of hook in source-dir with env, stops at the first failure
+func (h hook) run(env []string, stdout, stderr io.Writer) error {
+	for _, command := range h.commands {
+		fmt.Fprintf(stdout, "%s hook: %s\n", h.name, command)
+
+		c := newCommand("/bin/sh", "-c", command)
+		if runtime.GOOS == "windows" {
+			c = newCommand("cmd", "/C", command)
+		}
+		err := c.Dir(sourceDir).Env(env...).Stdout(stdout).Stderr(stderr).Run()
+		if err != nil {
+			return fmt.Errorf("%s hook `%s` failed: %v", h.name, command, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// includeFile is a file or dir from --include to add into the archives
+type includeFile struct {
+	name string // entry name in archive
+	path string // path on disk
+}
+
+// resolveIncludes expands the --include patterns in the form of glob[:dest],
+// the glob is relative to source-dir and dest is a dir in the archive.
+func resolveIncludes(patterns []string) ([]includeFile, error) {
+	var files []includeFile
+	for _, pattern := range patterns {
+		glob, dest := pattern, ""
+		if i := strings.LastIndex(pattern, ":"); i >= 0 {
+			glob, dest = pattern[:i], pattern[i+1:]
+		}
+
+		matches, err := filepath.Glob(filepath.Join(sourceDir, glob))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --include %s: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match --include %s", pattern)
+		}
+
+		for _, match := range matches {
+			name := path.Join(filepath.ToSlash(dest), filepath.Base(match))
+			files = append(files, includeFile{name: name, path: match})
+		}
+	}
+	return files, nil
+}
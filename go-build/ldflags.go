@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// defaultLdflagVars are injected unless overridden by --ldflag-var
+var defaultLdflagVars = []string{
+	"main.buildVersion={{.Version}}",
+	"main.buildGitRev={{.GitRev}}",
+	"main.buildGitCommit={{.GitCommit}}",
+	"main.buildDate={{.Date}}",
+}
+
+// ldflagData is the data to render --ldflag-var templates
+type ldflagData struct {
+	Name      string
+	Version   string
+	GitCommit string
+	GitRev    string
+	GitTag    string
+	Date      string
+	GOOS      string
+	GOARCH    string
+}
+
+// ldflagVar is a variable injected by `-ldflags -X name=value`
+type ldflagVar struct {
+	name   string
+	tmpl   *template.Template
+	verify bool // declared by --ldflag-var, warned if not set
+}
+
+// parseLdflagVars parses the default vars and --ldflag-var in the form of name=template,
+// a var declared later overrides the earlier one with same name.
+func parseLdflagVars(specs []string) ([]ldflagVar, error) {
+	var vars []ldflagVar
+	index := map[string]int{}
+	for i, spec := range append(defaultLdflagVars, specs...) {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid --ldflag-var %s, should be name=template", spec)
+		}
+		tmpl, err := template.New(kv[0]).Option("missingkey=error").Parse(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid --ldflag-var %s: %v", spec, err)
+		}
+
+		v := ldflagVar{name: kv[0], tmpl: tmpl, verify: i >= len(defaultLdflagVars)}
+		if i, ok := index[v.name]; ok {
+			vars[i] = v
+		} else {
+			index[v.name] = len(vars)
+			vars = append(vars, v)
+		}
+	}
+	return vars, nil
+}
+
+// render returns the value of the var
+func (v ldflagVar) render(data ldflagData) (string, error) {
+	var buf bytes.Buffer
+	if err := v.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid --ldflag-var %s: %v", v.name, err)
+	}
+	return buf.String(), nil
+}
+
+// ldflagX returns the `-X name=value` flag, quoted for go build -ldflags
+// which splits fields by spaces, and has no escapes in quoted fields.
+func ldflagX(name, value string) (string, error) {
+	switch {
+	case !strings.Contains(value, "'"):
+		return fmt.Sprintf("-X '%s=%s'", name, value), nil
+	case !strings.Contains(value, `"`):
+		return fmt.Sprintf(`-X "%s=%s"`, name, value), nil
+	}
+	return "", fmt.Errorf("invalid --ldflag-var %s, value contains both ' and \": %s", name, value)
+}
+
+// verifyLdflagVars returns the names of vars which are not set by -X when building pkg.
+//
+// The linker silently ignores -X for an undefined or non-string var, or a var initialized
+// by a function call or other vars. The symbol table of binary is stripped by -s,
+// so the declarations of vars are checked in the sources of pkg and its dependencies.
+func verifyLdflagVars(pkg mainPackage, settings goSettings, env []string, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	args := []string{"list", "-deps", "-json"}
+	if len(settings.tags) > 0 {
+		args = append(args, "-tags", strings.Join(settings.tags, ","))
+	}
+	if pkg.importPath == "" {
+		args = append(args, ".")
+	} else {
+		args = append(args, pkg.importPath)
+	}
+	out, _, err := newCommand("go", args...).Dir(sourceDir).Env(env...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list dependencies: %v", err)
+	}
+
+	// -X main.name is the var in the main package
+	packages := map[string]listedPackage{}
+	dec := json.NewDecoder(strings.NewReader(out))
+	for dec.More() {
+		var p listedPackage
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("unable to list dependencies: %v", err)
+		}
+		if p.Name == "main" {
+			p.ImportPath = "main"
+		}
+		packages[p.ImportPath] = p
+	}
+
+	var missing []string
+	for _, name := range names {
+		i := strings.LastIndex(name, ".")
+		if i <= 0 {
+			missing = append(missing, name)
+			continue
+		}
+		p, ok := packages[name[:i]]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		declared, err := isStringVarDeclared(p, name[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		if !declared {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// isStringVarDeclared returns true if a package-level string var is declared in the
+// go files of p, and it is uninitialized or initialized by a constant expression.
+func isStringVarDeclared(p listedPackage, name string) (bool, error) {
+	var vars, consts []*ast.ValueSpec
+	fset := token.NewFileSet()
+	for _, file := range append(p.GoFiles, p.CgoFiles...) {
+		f, err := parser.ParseFile(fset, filepath.Join(p.Dir, file), nil, 0)
+		if err != nil {
+			return false, err
+		}
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				switch gen.Tok {
+				case token.VAR:
+					vars = append(vars, spec.(*ast.ValueSpec))
+				case token.CONST:
+					consts = append(consts, spec.(*ast.ValueSpec))
+				}
+			}
+		}
+	}
+
+	// string constants of the package, used by initializers
+	stringConsts := map[string]bool{}
+	for _, vs := range consts {
+		for j, ident := range vs.Names {
+			stringConsts[ident.Name] = isStringType(vs.Type) || vs.Type == nil && j < len(vs.Values) && isConstString(vs.Values[j], nil)
+		}
+	}
+
+	for _, vs := range vars {
+		for j, ident := range vs.Names {
+			if ident.Name != name {
+				continue
+			}
+			if vs.Type != nil && !isStringType(vs.Type) {
+				return false, nil
+			}
+			if len(vs.Values) == 0 {
+				return vs.Type != nil, nil
+			}
+			return j < len(vs.Values) && isConstString(vs.Values[j], stringConsts), nil
+		}
+	}
+	return false, nil
+}
+
+// isStringType returns true if typ is string
+func isStringType(typ ast.Expr) bool {
+	ident, ok := typ.(*ast.Ident)
+	return ok && ident.Name == "string"
+}
+
+// isConstString returns true if expr is a constant string expression of literals and
+// consts, the constants of other packages are assumed to be strings.
+func isConstString(expr ast.Expr, consts map[string]bool) bool {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return e.Kind == token.STRING
+	case *ast.Ident:
+		return consts[e.Name]
+	case *ast.SelectorExpr:
+		return true
+	case *ast.ParenExpr:
+		return isConstString(e.X, consts)
+	case *ast.BinaryExpr:
+		return e.Op == token.ADD && isConstString(e.X, consts) && isConstString(e.Y, consts)
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// linuxPackage collects the files and metadata of a deb or rpm package,
+// the package is written by Close() of debArchive or rpmArchive.
+type linuxPackage struct {
+	filename    string
+	name        string
+	version     string
+	goarch      string
+	variant     string
+	maintainer  string
+	description string
+	depends     []packageDepend
+	scripts     map[string]string // preinstall, postinstall, preremove, postremove
+	modTime     time.Time
+	files       []packageFile
+}
+
+// packageFile is a regular file in the package
+type packageFile struct {
+	name string // absolute install path
+	path string // path on disk
+	mode os.FileMode
+	size int64
+}
+
+// packageDepend is a dependency in the form of `name [op version]`
+type packageDepend struct {
+	name    string
+	op      string // <, <=, =, >=, >
+	version string
+}
+
+var packageDependRegexp = regexp.MustCompile(`^([^\s()<>=]+)\s*\(?\s*(<<|>>|<=|>=|<|>|=)?\s*([^\s()]*)\s*\)?$`)
+
+// isLinuxPackageFormat returns true for deb and rpm
+func isLinuxPackageFormat(format string) bool {
+	return format == "deb" || format == "rpm"
+}
+
+// hasLinuxPackageFormat returns true if --archive or any target in config file is deb or rpm
+func hasLinuxPackageFormat() bool {
+	if isLinuxPackageFormat(archiveFmt) {
+		return true
+	}
+	for _, tc := range targetConfigs {
+		if isLinuxPackageFormat(tc.Archive) {
+			return true
+		}
+	}
+	return false
+}
+
+// newLinuxPackage creates a deb or rpm archive named name for the target,
+// name is --app-name for bundle, or the binary name of each package.
+func newLinuxPackage(filename, name, format string, t target, modTime time.Time) (archiveWriter, error) {
+	if t.goos != "linux" {
+		return nil, fmt.Errorf("%s archive is only supported for linux", format)
+	}
+
+	p := &linuxPackage{
+		filename:    filename,
+		name:        name,
+		version:     appVersion,
+		goarch:      t.goarch,
+		variant:     t.variant,
+		maintainer:  pkgMaintainer,
+		description: pkgDescription,
+		scripts:     map[string]string{},
+		modTime:     modTime,
+	}
+	if p.description == "" {
+		p.description = name
+	}
+	if p.modTime.IsZero() {
+		p.modTime = time.Now()
+	}
+
+	for _, spec := range pkgDepends {
+		m := packageDependRegexp.FindStringSubmatch(strings.TrimSpace(spec))
+		if m == nil || (m[2] == "") != (m[3] == "") {
+			return nil, fmt.Errorf("invalid --pkg-depends %s, should be name [op version]", spec)
+		}
+		op := strings.Replace(strings.Replace(m[2], "<<", "<", 1), ">>", ">", 1)
+		p.depends = append(p.depends, packageDepend{name: m[1], op: op, version: m[3]})
+	}
+
+	for name, file := range map[string]string{
+		"preinstall":  pkgPreinstall,
+		"postinstall": pkgPostinstall,
+		"preremove":   pkgPreremove,
+		"postremove":  pkgPostremove,
+	} {
+		if file == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(sourceDir, file))
+		if err != nil {
+			return nil, err
+		}
+		p.scripts[name] = string(data)
+	}
+
+	if pkgSystemdUnit != "" {
+		file := filepath.Join(sourceDir, pkgSystemdUnit)
+		if err := p.Add(path.Join("/lib/systemd/system", filepath.Base(file)), file); err != nil {
+			return nil, err
+		}
+	}
+
+	if format == "deb" {
+		return debArchive{p}, nil
+	}
+	return rpmArchive{p}, nil
+}
+
+// packageEntryName returns the install path of a file in deb or rpm package pkgName,
+// binaries are installed into --pkg-install-dir, and extra files
+// into /usr/share/doc/<pkgName>/ unless the dest is absolute.
+func packageEntryName(pkgName, name string, isBinary bool) string {
+	if isBinary {
+		return path.Join(pkgInstallDir, name)
+	}
+	if path.IsAbs(name) {
+		return name
+	}
+	return path.Join("/usr/share/doc", pkgName, name)
+}
+
+// Add a file to the package
+func (p *linuxPackage) Add(name, file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("unable to add dir into package: %s", file)
+	}
+
+	p.files = append(p.files, packageFile{
+		name: path.Clean("/" + name),
+		path: file,
+		mode: info.Mode().Perm(),
+		size: info.Size(),
+	})
+	return nil
+}
+
+// AddDir adds all files in a dir to the package
+func (p *linuxPackage) AddDir(name, dir string) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		return p.Add(path.Join(name, filepath.ToSlash(rel)), file)
+	})
+}
+
+// arch returns the package architecture of goarch/variant or goarch
+func (p *linuxPackage) arch(format string, archs map[string]string) (string, error) {
+	if arch, ok := archs[p.goarch+"/"+p.variant]; ok {
+		return arch, nil
+	}
+	if arch, ok := archs[p.goarch]; ok {
+		return arch, nil
+	}
+	return "", fmt.Errorf("%s archive is not supported for %s", format, p.goarch)
+}
+
+// sortedFiles returns the files sorted by install path
+func (p *linuxPackage) sortedFiles() []packageFile {
+	files := append([]packageFile(nil), p.files...)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+	return files
+}
+
+// installedSize returns the total size of files
+func (p *linuxPackage) installedSize() int64 {
+	var size int64
+	for _, f := range p.files {
+		size += f.size
+	}
+	return size
+}
+
+// isConfigFile returns true for files in /etc
+func (f packageFile) isConfigFile() bool {
+	return strings.HasPrefix(f.name, "/etc/")
+}
+
+// isDocFile returns true for files in /usr/share/doc
+func (f packageFile) isDocFile() bool {
+	return strings.HasPrefix(f.name, "/usr/share/doc/")
+}
+
+// copyFile copies the content of file into w
+func copyFile(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// universalArch is the GOARCH of darwin universal target,
+// the binaries of universalArchs are merged into a fat Mach-O binary.
+const universalArch = "universal"
+
+var universalArchs = []string{"amd64", "arm64"}
+
+// archs returns the targets to compile for the target
+func (t target) archs() []target {
+	if t.goarch != universalArch {
+		return []target{t}
+	}
+	archs := make([]target, 0, len(universalArchs))
+	for _, arch := range universalArchs {
+		archs = append(archs, target{goos: t.goos, goarch: arch})
+	}
+	return archs
+}
+
+// buildUniversal compiles the binary for all archs of the target,
+// and merges them into a fat Mach-O binary.
+func buildUniversal(t target, output string, compile func(t target, output string) error) error {
+	var inputs []string
+	defer func() {
+		for _, file := range inputs {
+			os.Remove(file)
+		}
+	}()
+
+	for _, arch := range t.archs() {
+		file := output + "." + arch.goarch
+		inputs = append(inputs, file)
+		if err := compile(arch, file); err != nil {
+			return err
+		}
+	}
+	return mergeMachO(output, inputs)
+}
+
+// fat Mach-O header
+const (
+	fatMagic     = 0xcafebabe
+	fatArchSize  = 20
+	fatAlignment = 14 // 16K page, required by arm64
+)
+
+// mergeMachO writes a fat Mach-O binary containing the thin binaries of inputs
+func mergeMachO(output string, inputs []string) error {
+	type slice struct {
+		file   string
+		cpu    macho.Cpu
+		subCpu uint32
+		offset int64
+		size   int64
+	}
+
+	offset := int64(8 + fatArchSize*len(inputs))
+	slices := make([]slice, 0, len(inputs))
+	for _, file := range inputs {
+		f, err := macho.Open(file)
+		if err != nil {
+			return fmt.Errorf("unable to merge %s: %v", file, err)
+		}
+		s := slice{file: file, cpu: f.Cpu, subCpu: f.SubCpu}
+		f.Close()
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		s.size = info.Size()
+
+		align := int64(1) << fatAlignment
+		s.offset = (offset + align - 1) / align * align
+		offset = s.offset + s.size
+		slices = append(slices, s)
+	}
+	if offset > 1<<32-1 {
+		return fmt.Errorf("unable to merge %s: universal binary is larger than 4GB", output)
+	}
+
+	w, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	header := []uint32{fatMagic, uint32(len(slices))}
+	for _, s := range slices {
+		header = append(header, uint32(s.cpu), s.subCpu, uint32(s.offset), uint32(s.size), fatAlignment)
+	}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
+	}
+
+	pos := int64(4 * len(header))
+	for _, s := range slices {
+		if _, err := w.Write(make([]byte, s.offset-pos)); err != nil {
+			return err
+		}
+		f, err := os.Open(s.file)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		pos = s.offset + s.size
+	}
+	return w.Close()
+}
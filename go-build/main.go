@@ -3,15 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/subchen/go-cli"
-	"github.com/subchen/go-stack/cmd"
-	"github.com/subchen/go-stack/encoding/archive"
 	"github.com/subchen/go-stack/fs"
-	"github.com/subchen/go-stack/runs"
 )
 
 // version
@@ -25,11 +20,56 @@ var (
 var (
 	appName    string
 	appVersion string
+	versionSrc string
 	goos       string
 	goarch     string
 	archiveFmt string
 	sourceDir  string
 	outputDir  string
+	parallel   int
+	ldflags    string
+	gcflags    string
+	asmflags   string
+	buildmode  string
+	configFile string
+
+	reproducible bool
+	verifyRepro  bool
+	bundle       bool
+	force        bool
+
+	packagePatterns []string
+	includePatterns []string
+	ldflagVars      []string
+	sbomFormats     []string
+	targetSpecs     []string
+	buildEnv        []string
+	buildTags       []string
+	ignoreSpecs     []string
+
+	nameTmpl     string
+	nameReplaces []string
+
+	beforeAllHooks  []string
+	beforeEachHooks []string
+	afterEachHooks  []string
+	afterAllHooks   []string
+
+	pkgMaintainer  string
+	pkgDescription string
+	pkgDepends     []string
+	pkgInstallDir  string
+	pkgSystemdUnit string
+	pkgPreinstall  string
+	pkgPostinstall string
+	pkgPreremove   string
+	pkgPostremove  string
+
+	winRes         bool
+	winCompany     string
+	winDescription string
+	winCopyright   string
+	winIcon        string
 )
 
 func main() {
@@ -50,6 +90,11 @@ func main() {
 			Usage: "application version",
 			Value: &appVersion,
 		},
+		{
+			Name:  "version-from",
+			Usage: "compute application version if no --app-version provided: git",
+			Value: &versionSrc,
+		},
 		{
 			Name:     "goos",
 			Usage:    "go build target os: GOOS",
@@ -62,45 +107,290 @@ func main() {
 			Value:    &goarch,
 			DefValue: "amd64",
 		},
+		{
+			Name:        "t, target",
+			Usage:       "go build target instead of --goos and --goarch, variant is GOARM, GOAMD64, GO386 or GOMIPS, darwin/universal is a fat binary of amd64 and arm64, can be repeated",
+			Placeholder: "goos/goarch[/variant]",
+			Value:       &targetSpecs,
+		},
+		{
+			Name:        "ignore",
+			Usage:       "exclude targets from build, can be repeated",
+			Placeholder: "goos/goarch[/variant]",
+			Value:       &ignoreSpecs,
+		},
+		{
+			Name:        "p, package",
+			Usage:       "main packages to build, relative to source-dir, default is source-dir named as --app-name, can be repeated",
+			Placeholder: "pattern",
+			Value:       &packagePatterns,
+		},
 		{
 			Name:  "f, archive",
-			Usage: "archive format: zip or tar.gz, default is not archived",
+			Usage: "archive format: zip, tar.gz, deb or rpm, default is not archived",
 			Value: &archiveFmt,
 		},
+		{
+			Name:  "bundle",
+			Usage: "archive the binaries of all packages into one archive per target",
+			Value: &bundle,
+		},
+		{
+			Name:        "i, include",
+			Usage:       "extra files or dirs to archive, relative to source-dir, can be repeated",
+			Placeholder: "glob[:dest]",
+			Value:       &includePatterns,
+		},
+		{
+			Name:  "pkg-maintainer",
+			Usage: "deb/rpm package maintainer, default is the author of last git commit",
+			Value: &pkgMaintainer,
+		},
+		{
+			Name:  "pkg-description",
+			Usage: "deb/rpm package description, default is --app-name",
+			Value: &pkgDescription,
+		},
+		{
+			Name:        "pkg-depends",
+			Usage:       "deb/rpm package dependency, op is one of < <= = >= >, can be repeated",
+			Placeholder: "name [op version]",
+			Value:       &pkgDepends,
+		},
+		{
+			Name:     "pkg-install-dir",
+			Usage:    "deb/rpm package binaries install dir",
+			Value:    &pkgInstallDir,
+			DefValue: "/usr/bin",
+		},
+		{
+			Name:  "pkg-systemd-unit",
+			Usage: "deb/rpm package systemd unit file, relative to source-dir",
+			Value: &pkgSystemdUnit,
+		},
+		{
+			Name:  "pkg-preinstall",
+			Usage: "deb/rpm package pre-install script file, relative to source-dir",
+			Value: &pkgPreinstall,
+		},
+		{
+			Name:  "pkg-postinstall",
+			Usage: "deb/rpm package post-install script file, relative to source-dir",
+			Value: &pkgPostinstall,
+		},
+		{
+			Name:  "pkg-preremove",
+			Usage: "deb/rpm package pre-remove script file, relative to source-dir",
+			Value: &pkgPreremove,
+		},
+		{
+			Name:  "pkg-postremove",
+			Usage: "deb/rpm package post-remove script file, relative to source-dir",
+			Value: &pkgPostremove,
+		},
+		{
+			Name:     "name-template",
+			Usage:    "file name of binaries and archives, template fields: .Name .Version .Os .Arch .Arm .Variant .Ext",
+			Value:    &nameTmpl,
+			DefValue: defaultNameTemplate,
+		},
+		{
+			Name:        "name-replace",
+			Usage:       "replace .Os .Arch .Arm .Variant in --name-template, e.g. amd64=x86_64, can be repeated",
+			Placeholder: "from=to",
+			Value:       &nameReplaces,
+		},
+		{
+			Name:  "win-resource",
+			Usage: "embed version info resource into windows binaries, by a zz_go_build_windows_<arch>.syso file in package dir during go build",
+			Value: &winRes,
+		},
+		{
+			Name:  "win-company",
+			Usage: "windows resource company name",
+			Value: &winCompany,
+		},
+		{
+			Name:  "win-description",
+			Usage: "windows resource file description, default is --app-name",
+			Value: &winDescription,
+		},
+		{
+			Name:  "win-copyright",
+			Usage: "windows resource legal copyright",
+			Value: &winCopyright,
+		},
+		{
+			Name:  "win-icon",
+			Usage: "windows resource icon .ico file, relative to source-dir",
+			Value: &winIcon,
+		},
 		{
 			Name:     "o, output-dir",
 			Usage:    "build target dir",
 			Value:    &outputDir,
 			DefValue: "./_releases",
 		},
+		{
+			Name:  "ldflags",
+			Usage: "additional go build -ldflags",
+			Value: &ldflags,
+		},
+		{
+			Name:  "gcflags",
+			Usage: "go build -gcflags",
+			Value: &gcflags,
+		},
+		{
+			Name:  "asmflags",
+			Usage: "go build -asmflags",
+			Value: &asmflags,
+		},
+		{
+			Name:        "tags",
+			Usage:       "go build -tags, can be repeated",
+			Placeholder: "tag,...",
+			Value:       &buildTags,
+		},
+		{
+			Name:  "buildmode",
+			Usage: "go build -buildmode",
+			Value: &buildmode,
+		},
+		{
+			Name:        "env",
+			Usage:       "env var for go build, e.g. CGO_ENABLED=0, can be repeated",
+			Placeholder: "name=value",
+			Value:       &buildEnv,
+		},
+		{
+			Name:        "ldflag-var",
+			Usage:       "inject var by -ldflags -X, template fields: .Name .Version .GitCommit .GitRev .GitTag .Date .GOOS .GOARCH, can be repeated",
+			Placeholder: "name=template",
+			Value:       &ldflagVars,
+		},
+		{
+			Name:        "before-all",
+			Usage:       "shell command to run before all targets, can be repeated",
+			Placeholder: "command",
+			Value:       &beforeAllHooks,
+		},
+		{
+			Name:        "before-each",
+			Usage:       "shell command to run before each target with $GOOS $GOARCH exported, can be repeated",
+			Placeholder: "command",
+			Value:       &beforeEachHooks,
+		},
+		{
+			Name:        "after-each",
+			Usage:       "shell command to run after each target is compiled, before archived, the binaries are $BUILD_OUTPUT, can be repeated",
+			Placeholder: "command",
+			Value:       &afterEachHooks,
+		},
+		{
+			Name:        "after-all",
+			Usage:       "shell command to run after all targets succeeded, the manifest is $BUILD_MANIFEST, can be repeated",
+			Placeholder: "command",
+			Value:       &afterAllHooks,
+		},
+		{
+			Name:        "sbom",
+			Usage:       "generate SBOM of binaries into output-dir: spdx, cyclonedx, can be repeated, versions of GOPATH dependencies are only read from glide.lock",
+			Placeholder: "format",
+			Value:       &sbomFormats,
+		},
+		{
+			Name:  "config",
+			Usage: "build config file, default is source-dir/go-build.yaml",
+			Value: &configFile,
+		},
+		{
+			Name:  "reproducible",
+			Usage: "reproducible builds, build time from $SOURCE_DATE_EPOCH or last commit",
+			Value: &reproducible,
+		},
+		{
+			Name:  "verify-reproducible",
+			Usage: "build twice in reproducible mode and compare the checksums",
+			Value: &verifyRepro,
+		},
+		{
+			Name:  "force",
+			Usage: "rebuild all targets, even if the artifacts are up to date",
+			Value: &force,
+		},
+		{
+			Name:     "parallel",
+			Usage:    "number of targets to build in parallel",
+			Value:    &parallel,
+			DefValue: "1",
+		},
 	}
 
-	app.Action = func(c *cli.Context) {
-		if len(os.Args) == 0 {
-			c.ShowHelpAndExit(0)
-		}
-
-		if appName == "" {
-			panic("no --app-name provided")
-		}
-		if appVersion == "" {
-			panic("no --app-version provided")
-		}
+	app.Commands = []*cli.Command{
+		{
+			Name:      "version",
+			Usage:     "print the application version computed from git tags",
+			UsageText: " [source-dir]",
+			Action: func(c *cli.Context) {
+				warnCommandDir(c)
+				dir := "."
+				if c.NArg() > 0 {
+					dir = c.Args()[0]
+				}
+				version, err := gitVersion(dir)
+				exitIfErr(c, err)
+				fmt.Println(version)
+			},
+		},
+		{
+			Name:      "watch",
+			Usage:     "rebuild the host target when sources changed, and restart --run command",
+			UsageText: " [OPTIONS...] [source-dir]",
+			Flags: []*cli.Flag{
+				{
+					Name:        "run",
+					Usage:       "shell command to restart after each successful build, $BUILD_OUTPUT is the binaries",
+					Placeholder: "command",
+					Value:       &watchRun,
+				},
+				{
+					Name:     "interval",
+					Usage:    "interval to poll source files",
+					Value:    &watchInterval,
+					DefValue: "500ms",
+				},
+				{
+					Name:     "debounce",
+					Usage:    "quiet period after the last change before rebuilding",
+					Value:    &watchDebounce,
+					DefValue: "300ms",
+				},
+			},
+			Action: func(c *cli.Context) {
+				warnCommandDir(c)
+				exitIfErr(c, setup(c.Global(), app.Flags, c.Args()))
+				exitIfErr(c, watch())
+			},
+		},
+	}
 
-		sourceDir = "."
-		if c.NArg() == 1 {
-			sourceDir = c.Args()[0]
-		}
+	// source-dir is not a command
+	app.OnCommandNotFound = func(c *cli.Context, command string) {}
 
-		if !fs.IsDir(sourceDir) {
-			panic("source-dir does not exists")
-		}
+	// go-cli exits with status 1 on invalid options
+	app.OnUsageError = func(c *cli.Context, err error) {
+		exitUsageError(c.Name(), err)
+	}
 
-		if !fs.IsDir(outputDir) {
-			os.MkdirAll(outputDir, 0755)
+	app.Action = func(c *cli.Context) {
+		if len(os.Args) == 0 {
+			c.ShowHelpAndExit(0)
 		}
 
-		gobuild()
+		exitIfErr(c, setup(c, app.Flags, c.Args()))
+		_, err := gobuild()
+		exitIfErr(c, err)
 	}
 
 	if buildVersion != "" {
@@ -112,67 +402,66 @@ func main() {
 	app.Run(os.Args)
 }
 
-func gobuild() {
-	buildDate := time.Now().Format(time.RFC1123Z)
-	buildGitRev, err := cmd.ShellOutput(fmt.Sprintf("cd %s && git rev-list HEAD --count", sourceDir))
-	runs.PanicIfErr(err)
-	buildGitCommit, err := cmd.ShellOutput(fmt.Sprintf("cd %s && git describe --abbrev=0 --always", sourceDir))
-	runs.PanicIfErr(err)
-
-	ldflags := []string{
-		"-s",
-		"-w",
-		fmt.Sprintf("-X 'main.buildVersion=%s'", appVersion),
-		fmt.Sprintf("-X 'main.buildDate=%s'", buildDate),
-		fmt.Sprintf("-X 'main.BuildGitRev=%s'", buildGitRev),
-		fmt.Sprintf("-X 'main.BuildGitCommit=%s'", buildGitCommit),
+// warnCommandDir warns if a dir in working dir has the same name as the command,
+// it is not taken as source-dir, which should be given as ./<dir> or after --
+func warnCommandDir(c *cli.Context) {
+	name := c.Name()[strings.LastIndex(c.Name(), " ")+1:]
+	if fs.IsDir(name) {
+		fmt.Fprintf(os.Stderr, "warning: '%s' is run as command, use ./%s for source-dir\n", name, name)
 	}
+}
 
-	for _, goos := range strings.Split(goos, ",") {
-		goos = strings.TrimSpace(goos)
-		for _, goarch := range strings.Split(goarch, ",") {
-			goarch = strings.TrimSpace(goarch)
+// setup resolves source-dir from args, loads the config file and checks the required flags
+func setup(c *cli.Context, flags []*cli.Flag, args []string) error {
+	if len(args) > 1 {
+		return usageErrorf("too many arguments, options must be placed before source-dir")
+	}
 
-			filename := fmt.Sprintf("%s-%s-%s-%s", appName, appVersion, goos, goarch)
-			if goos == "windows" {
-				filename += ".exe"
-			}
-			outputFilename := filepath.Join(outputDir, filename)
-
-			fmt.Printf("go build: %s ...\n", outputFilename)
-			cmdline := fmt.Sprintf(
-				`cd "%s" && GOOS=%s GOARCH=%s go build -ldflags "%s" -o "%s"`,
-				sourceDir,
-				goos,
-				goarch,
-				strings.Join(ldflags, " "),
-				outputFilename,
-			)
-			err := cmd.Shell(cmdline)
-			runs.PanicIfErr(err)
-
-			// archive
-			if archiveFmt != "" {
-				archiveFilename := strings.TrimSuffix(filename, ".exe") + "." + archiveFmt
-				archiveFilename = filepath.Join(outputDir, archiveFilename)
-				fmt.Printf("archived: %s ...\n", archiveFilename)
-
-				a := archive.New(archiveFilename)
-				defer a.Close()
-
-				entryName := appName
-				if goos == "windows" {
-					entryName += ".exe"
-				}
-				err := a.Add(entryName, outputFilename)
-				runs.PanicIfErr(err)
+	sourceDir = "."
+	if len(args) == 1 {
+		sourceDir = args[0]
+	}
+
+	if !fs.IsDir(sourceDir) {
+		return usageErrorf("source-dir does not exists")
+	}
+
+	if err := loadConfig(c, flags, configFile); err != nil {
+		return asUsageError(err)
+	}
 
-				// remove binary
-				err = os.Remove(outputFilename)
-				runs.PanicIfErr(err)
+	if archiveFmt != "" && !isArchiveFormat(archiveFmt) {
+		return usageErrorf("invalid --archive: %s, should be one of: %s", archiveFmt, strings.Join(archiveFormats, ", "))
+	}
+
+	if appName == "" {
+		return usageErrorf("no --app-name provided")
+	}
+	if appVersion == "" {
+		switch versionSrc {
+		case "":
+			return usageErrorf("no --app-version provided")
+		case "git":
+			version, err := gitVersion(sourceDir)
+			if err != nil {
+				return err
 			}
+			appVersion = version
+		default:
+			return usageErrorf("invalid --version-from: %s", versionSrc)
 		}
 	}
 
-	fmt.Println("go build: Completed!")
+	if pkgMaintainer == "" && hasLinuxPackageFormat() {
+		maintainer, err := gitAuthor(sourceDir)
+		if err != nil {
+			return usageErrorf("no --pkg-maintainer provided and no git author found, it is required by deb and rpm")
+		}
+		pkgMaintainer = maintainer
+	}
+
+	if !fs.IsDir(outputDir) {
+		return os.MkdirAll(outputDir, 0755)
+	}
+	return nil
 }
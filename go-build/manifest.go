@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/subchen/go-stack/encoding/sha256"
+	"github.com/subchen/go-stack/fs"
+)
+
+// manifestFile is written into output-dir after build
+const manifestFile = "manifest.json"
+
+// manifest describes all artifacts of a build
+type manifest struct {
+	Artifacts []artifact `json:"artifacts"`
+}
+
+// artifact is a binary or an archive in output-dir
+type artifact struct {
+	Path      string   `json:"path"`             // relative to output-dir
+	Format    string   `json:"format,omitempty"` // archive format, empty for binary
+	GOOS      string   `json:"goos"`
+	GOARCH    string   `json:"goarch"`
+	Variant   string   `json:"variant,omitempty"`
+	Binaries  []string `json:"binaries"`
+	SBOMs     []string `json:"sboms,omitempty"` // relative to output-dir
+	Size      int64    `json:"size"`
+	SHA256    string   `json:"sha256"`
+	Version   string   `json:"version"`
+	GitCommit string   `json:"git_commit"`
+	BuildDate string   `json:"build_date"`
+}
+
+// newArtifact returns the artifact of a built file
+func newArtifact(file, format string, t target, binaries []binaryFile, data ldflagData) (artifact, error) {
+	sum, err := sha256.SumFile(file)
+	if err != nil {
+		return artifact{}, err
+	}
+
+	names := make([]string, 0, len(binaries))
+	var sboms []string
+	for _, b := range binaries {
+		names = append(names, b.name)
+		for _, file := range b.sboms {
+			sboms = append(sboms, filepath.Base(file))
+		}
+	}
+
+	return artifact{
+		Path:      filepath.Base(file),
+		Format:    format,
+		GOOS:      t.goos,
+		GOARCH:    t.goarch,
+		Variant:   t.variant,
+		Binaries:  names,
+		SBOMs:     sboms,
+		Size:      fs.FileGetSize(file),
+		SHA256:    sum,
+		Version:   data.Version,
+		GitCommit: data.GitCommit,
+		BuildDate: data.Date,
+	}, nil
+}
+
+// writeManifest writes the artifacts of all built targets into output-dir
+func writeManifest(results []buildResult) (string, error) {
+	m := manifest{Artifacts: []artifact{}}
+	for _, r := range results {
+		m.Artifacts = append(m.Artifacts, r.artifacts...)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	filename := filepath.Join(outputDir, manifestFile)
+	return filename, fs.FileWriteBytes(filename, append(data, '\n'))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// defaultNameTemplate is the default --name-template
+const defaultNameTemplate = "{{.Name}}-{{.Version}}-{{.Os}}-{{.Arch}}{{.Variant}}{{.Ext}}"
+
+// nameData is the data to render --name-template
+type nameData struct {
+	Name    string // package name or app name for bundle
+	Version string
+	Os      string
+	Arch    string
+	Arm     string // GOARM of arm target
+	Variant string // arch variant, e.g. v7, v3, softfloat
+	Ext     string // .exe for windows binary, .<format> for archive
+}
+
+// nameTemplate renders the file names of binaries and archives
+type nameTemplate struct {
+	text         string
+	tmpl         *template.Template
+	replacements map[string]string
+}
+
+// parseNameTemplate parses --name-template and --name-replace in the form of from=to
+func parseNameTemplate(text string, replaces []string) (*nameTemplate, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --name-template %s: %v", text, err)
+	}
+
+	replacements := map[string]string{}
+	for _, spec := range replaces {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid --name-replace %s, should be from=to", spec)
+		}
+		replacements[kv[0]] = kv[1]
+	}
+
+	return &nameTemplate{text: text, tmpl: tmpl, replacements: replacements}, nil
+}
+
+// render returns the file name of a binary or archive of the target,
+// the replacements are applied to Os, Arch, Arm and Variant.
+func (n *nameTemplate) render(name string, t target, ext string) (string, error) {
+	data := nameData{
+		Name:    name,
+		Version: appVersion,
+		Os:      n.replace(t.goos),
+		Arch:    n.replace(t.goarch),
+		Arm:     n.replace(t.goarm()),
+		Variant: n.replace(t.variant),
+		Ext:     ext,
+	}
+
+	var buf bytes.Buffer
+	if err := n.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid --name-template: %v", err)
+	}
+
+	filename := buf.String()
+	if filename == "" || strings.ContainsAny(filename, `/\`) {
+		return "", fmt.Errorf("invalid --name-template, bad file name: %q", filename)
+	}
+	return filename, nil
+}
+
+func (n *nameTemplate) replace(value string) string {
+	if s, ok := n.replacements[value]; ok {
+		return s
+	}
+	return value
+}
+
+// checkArtifactNames returns an error if the names of artifacts are not unique
+func checkArtifactNames(targets []target, opts buildOptions) error {
+	binaries := map[string]string{}
+	bundles := map[string]string{}
+	check := func(seen map[string]string, name string, t target) error {
+		basename, err := opts.names.render(name, t, "")
+		if err != nil {
+			return err
+		}
+		key := name + " of " + t.String()
+		if prev, ok := seen[basename]; ok {
+			return fmt.Errorf("duplicated artifact name %s for %s and %s, check --name-template", basename, prev, key)
+		}
+		seen[basename] = key
+		return nil
+	}
+
+	for _, t := range targets {
+		for _, pkg := range opts.packages {
+			if err := check(binaries, pkg.name, t); err != nil {
+				return err
+			}
+		}
+		if bundle {
+			if err := check(bundles, appName, t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// mainPackage is a main package to build
+type mainPackage struct {
+	importPath string // empty for the package in source-dir
+	name       string // binary name
+	dir        string
+}
+
+// resolvePackages lists the main packages matching the --package patterns,
+// the package in source-dir named as --app-name is built if no patterns.
+func resolvePackages(patterns []string) ([]mainPackage, error) {
+	if len(patterns) == 0 {
+		return []mainPackage{{name: appName, dir: sourceDir}}, nil
+	}
+
+	args := append([]string{"list", "-f", "{{.Name}} {{.ImportPath}} {{.Dir}}"}, patterns...)
+	out, _, err := newCommand("go", args...).Dir(sourceDir).Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list packages %s: %v", strings.Join(patterns, " "), err)
+	}
+
+	var packages []mainPackage
+	names := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 || fields[0] != "main" {
+			continue
+		}
+
+		pkg := mainPackage{importPath: fields[1], name: path.Base(fields[1]), dir: fields[2]}
+		if other, ok := names[pkg.name]; ok {
+			return nil, fmt.Errorf("duplicate binary name %s: %s and %s", pkg.name, other, pkg.importPath)
+		}
+		names[pkg.name] = pkg.importPath
+		packages = append(packages, pkg)
+	}
+
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no main packages found in %s", strings.Join(patterns, " "))
+	}
+	return packages, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/subchen/go-stack/encoding/sha256"
+)
+
+// sourceDateEpoch returns the build time of reproducible builds,
+// from $SOURCE_DATE_EPOCH or the timestamp of the last commit.
+func sourceDateEpoch() (time.Time, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		out, err := gitOutput(sourceDir, "log", "-1", "--format=%ct")
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unable to get commit timestamp: %v", err)
+		}
+		epoch = out
+	}
+
+	sec, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %s", epoch)
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+// verifyReproducible builds all targets again into a temp dir,
+// and compares the checksums with the artifacts in output-dir.
+func verifyReproducible(targets []target, opts buildOptions) error {
+	dir, err := ioutil.TempDir("", "go-build-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	fmt.Printf("verify reproducible: rebuilding into %s ...\n", dir)
+	opts.outputDir = dir
+	opts.state = nil
+	for _, r := range buildTargets(targets, opts) {
+		if r.err != nil {
+			return fmt.Errorf("verify reproducible: %s failed: %v", r.target, r.err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var mismatched []string
+	for _, file := range files {
+		sum1, err := sha256.SumFile(filepath.Join(outputDir, file.Name()))
+		if err != nil {
+			return err
+		}
+		sum2, err := sha256.SumFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+
+		if sum1 == sum2 {
+			fmt.Printf("verify reproducible: %s OK\n", file.Name())
+		} else {
+			fmt.Printf("verify reproducible: %s MISMATCH %s != %s\n", file.Name(), sum1, sum2)
+			mismatched = append(mismatched, file.Name())
+		}
+	}
+
+	if len(mismatched) > 0 {
+		return fmt.Errorf("build is not reproducible: %s", strings.Join(mismatched, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// rpmArchive writes the package in rpm v3 format (lead, signature, header and cpio payload)
+type rpmArchive struct {
+	*linuxPackage
+}
+
+// rpmArchs maps GOARCH to rpm architecture
+var rpmArchs = map[string]string{
+	"386":      "i386",
+	"amd64":    "x86_64",
+	"arm":      "armv7hl",
+	"arm/v5":   "armv5tel",
+	"arm/v6":   "armv6hl",
+	"arm64":    "aarch64",
+	"mips":     "mips",
+	"mipsle":   "mipsel",
+	"mips64le": "mips64el",
+	"ppc64le":  "ppc64le",
+	"s390x":    "s390x",
+}
+
+// rpm header data types
+const (
+	rpmTypeInt16       = 3
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeBinary      = 7
+	rpmTypeStringArray = 8
+	rpmTypeI18NString  = 9
+)
+
+// rpm signature and header tags
+const (
+	rpmSigRegion      = 62
+	rpmSigSHA1        = 269
+	rpmSigSHA256      = 273
+	rpmSigSize        = 1000
+	rpmSigMD5         = 1004
+	rpmSigPayloadSize = 1007
+
+	rpmTagRegion            = 63
+	rpmTagI18NTable         = 100
+	rpmTagName              = 1000
+	rpmTagVersion           = 1001
+	rpmTagRelease           = 1002
+	rpmTagSummary           = 1004
+	rpmTagDescription       = 1005
+	rpmTagBuildTime         = 1006
+	rpmTagSize              = 1009
+	rpmTagVendor            = 1011
+	rpmTagLicense           = 1014
+	rpmTagPackager          = 1015
+	rpmTagGroup             = 1016
+	rpmTagOS                = 1021
+	rpmTagArch              = 1022
+	rpmTagPreIn             = 1023
+	rpmTagPostIn            = 1024
+	rpmTagPreUn             = 1025
+	rpmTagPostUn            = 1026
+	rpmTagFileSizes         = 1028
+	rpmTagFileModes         = 1030
+	rpmTagFileRdevs         = 1033
+	rpmTagFileMtimes        = 1034
+	rpmTagFileDigests       = 1035
+	rpmTagFileLinkTos       = 1036
+	rpmTagFileFlags         = 1037
+	rpmTagFileUserName      = 1039
+	rpmTagFileGroupName     = 1040
+	rpmTagSourceRPM         = 1044
+	rpmTagProvideName       = 1047
+	rpmTagRequireFlags      = 1048
+	rpmTagRequireName       = 1049
+	rpmTagRequireVersion    = 1050
+	rpmTagPreInProg         = 1085
+	rpmTagPostInProg        = 1086
+	rpmTagPreUnProg         = 1087
+	rpmTagPostUnProg        = 1088
+	rpmTagFileDevices       = 1095
+	rpmTagFileInodes        = 1096
+	rpmTagFileLangs         = 1097
+	rpmTagProvideFlags      = 1112
+	rpmTagProvideVersion    = 1113
+	rpmTagDirIndexes        = 1116
+	rpmTagBaseNames         = 1117
+	rpmTagDirNames          = 1118
+	rpmTagPayloadFormat     = 1124
+	rpmTagPayloadCompressor = 1125
+	rpmTagPayloadFlags      = 1126
+	rpmTagFileDigestAlgo    = 5011
+)
+
+// rpm file flags and dependency flags
+const (
+	rpmFileConfig = 1 << 0
+	rpmFileDoc    = 1 << 1
+
+	rpmSenseLess    = 1 << 1
+	rpmSenseGreater = 1 << 2
+	rpmSenseEqual   = 1 << 3
+	rpmSenseRPMLib  = 1 << 24
+)
+
+// rpmScripts maps the script names to header tags of script and interpreter
+var rpmScripts = map[string][2]int32{
+	"preinstall":  {rpmTagPreIn, rpmTagPreInProg},
+	"postinstall": {rpmTagPostIn, rpmTagPostInProg},
+	"preremove":   {rpmTagPreUn, rpmTagPreUnProg},
+	"postremove":  {rpmTagPostUn, rpmTagPostUnProg},
+}
+
+// rpmPostTagRegexp matches the `-<commits>-g<sha>` suffix of git version
+var rpmPostTagRegexp = regexp.MustCompile(`-(\d+)-(g[0-9a-f]+)(\+dirty)?$`)
+
+// rpmVersion converts the version to rpm, which cannot contain '-'.
+// The git version 1.2.3-5-gabc is 1.2.3^5.gabc, '^' sorts after 1.2.3 and before 1.2.4,
+// and a pre-release 1.2.3-rc1 is 1.2.3~rc1, '~' sorts before 1.2.3.
+func rpmVersion(version string) string {
+	postTag := ""
+	if m := rpmPostTagRegexp.FindStringSubmatch(version); m != nil {
+		version = strings.TrimSuffix(version, m[0])
+		postTag = "^" + m[1] + "." + m[2] + m[3]
+	}
+	return strings.Replace(version, "-", "~", -1) + postTag
+}
+
+// Close writes the .rpm file
+func (a rpmArchive) Close() error {
+	arch, err := a.arch("rpm", rpmArchs)
+	if err != nil {
+		return err
+	}
+
+	version := rpmVersion(a.version)
+	release := "1"
+
+	files := a.sortedFiles()
+	cpio, digests, err := a.cpioPayload(files)
+	if err != nil {
+		return err
+	}
+	payload, err := gzipBytes(cpio)
+	if err != nil {
+		return err
+	}
+
+	h := newRPMHeader(rpmTagRegion)
+	h.addStrings(rpmTagI18NTable, "C")
+	h.addString(rpmTagName, a.name)
+	h.addString(rpmTagVersion, version)
+	h.addString(rpmTagRelease, release)
+	h.addI18NString(rpmTagSummary, strings.SplitN(strings.TrimSpace(a.description), "\n", 2)[0])
+	h.addI18NString(rpmTagDescription, a.description)
+	h.addInt32(rpmTagBuildTime, int32(a.modTime.Unix()))
+	h.addInt32(rpmTagSize, int32(a.installedSize()))
+	h.addString(rpmTagLicense, "unspecified")
+	h.addString(rpmTagVendor, a.maintainer)
+	h.addString(rpmTagPackager, a.maintainer)
+	h.addI18NString(rpmTagGroup, "Unspecified")
+	h.addString(rpmTagOS, "linux")
+	h.addString(rpmTagArch, arch)
+	h.addString(rpmTagSourceRPM, fmt.Sprintf("%s-%s-%s.src.rpm", a.name, version, release))
+	h.addString(rpmTagPayloadFormat, "cpio")
+	h.addString(rpmTagPayloadCompressor, "gzip")
+	h.addString(rpmTagPayloadFlags, "9")
+
+	for name, script := range a.scripts {
+		tags := rpmScripts[name]
+		h.addString(tags[0], script)
+		h.addString(tags[1], "/bin/sh")
+	}
+
+	// provides itself
+	h.addStrings(rpmTagProvideName, a.name)
+	h.addInt32(rpmTagProvideFlags, rpmSenseEqual)
+	h.addStrings(rpmTagProvideVersion, version+"-"+release)
+
+	// requires the features of rpm and the dependencies
+	requireNames := []string{"rpmlib(CompressedFileNames)", "rpmlib(FileDigests)", "rpmlib(PayloadFilesHavePrefix)"}
+	requireVersions := []string{"3.0.4-1", "4.6.0-1", "4.0-1"}
+	requireFlags := []int32{rpmSenseRPMLib | rpmSenseLess | rpmSenseEqual, rpmSenseRPMLib | rpmSenseLess | rpmSenseEqual, rpmSenseRPMLib | rpmSenseLess | rpmSenseEqual}
+	if strings.Contains(version, "~") {
+		requireNames = append(requireNames, "rpmlib(TildeInVersions)")
+		requireVersions = append(requireVersions, "4.10.0-1")
+		requireFlags = append(requireFlags, rpmSenseRPMLib|rpmSenseLess|rpmSenseEqual)
+	}
+	if strings.Contains(version, "^") {
+		requireNames = append(requireNames, "rpmlib(CaretInVersions)")
+		requireVersions = append(requireVersions, "4.15.0-1")
+		requireFlags = append(requireFlags, rpmSenseRPMLib|rpmSenseLess|rpmSenseEqual)
+	}
+	for _, d := range a.depends {
+		requireNames = append(requireNames, d.name)
+		requireVersions = append(requireVersions, d.version)
+		requireFlags = append(requireFlags, d.rpmFlags())
+	}
+	h.addStrings(rpmTagRequireName, requireNames...)
+	h.addStrings(rpmTagRequireVersion, requireVersions...)
+	h.addInt32(rpmTagRequireFlags, requireFlags...)
+
+	if len(files) > 0 {
+		var (
+			sizes, mtimes, flags, devices, inodes, dirIndexes []int32
+			modes, rdevs                                      []int16
+			users, groups, linkTos, langs, baseNames          []string
+			dirNames                                          []string
+		)
+		dirIndex := map[string]int32{}
+		for i, f := range files {
+			dir := path.Dir(f.name) + "/"
+			if dir == "//" {
+				dir = "/"
+			}
+			if _, ok := dirIndex[dir]; !ok {
+				dirIndex[dir] = int32(len(dirNames))
+				dirNames = append(dirNames, dir)
+			}
+
+			var flag int32
+			if f.isConfigFile() {
+				flag |= rpmFileConfig
+			}
+			if f.isDocFile() {
+				flag |= rpmFileDoc
+			}
+
+			sizes = append(sizes, int32(f.size))
+			mtimes = append(mtimes, int32(a.modTime.Unix()))
+			flags = append(flags, flag)
+			devices = append(devices, 1)
+			inodes = append(inodes, int32(i+1))
+			dirIndexes = append(dirIndexes, dirIndex[dir])
+			modes = append(modes, int16(0100000|f.mode))
+			rdevs = append(rdevs, 0)
+			users = append(users, "root")
+			groups = append(groups, "root")
+			linkTos = append(linkTos, "")
+			langs = append(langs, "")
+			baseNames = append(baseNames, path.Base(f.name))
+		}
+
+		h.addInt32(rpmTagFileSizes, sizes...)
+		h.addInt16(rpmTagFileModes, modes...)
+		h.addInt16(rpmTagFileRdevs, rdevs...)
+		h.addInt32(rpmTagFileMtimes, mtimes...)
+		h.addStrings(rpmTagFileDigests, digests...)
+		h.addStrings(rpmTagFileLinkTos, linkTos...)
+		h.addInt32(rpmTagFileFlags, flags...)
+		h.addStrings(rpmTagFileUserName, users...)
+		h.addStrings(rpmTagFileGroupName, groups...)
+		h.addInt32(rpmTagFileDevices, devices...)
+		h.addInt32(rpmTagFileInodes, inodes...)
+		h.addStrings(rpmTagFileLangs, langs...)
+		h.addInt32(rpmTagDirIndexes, dirIndexes...)
+		h.addStrings(rpmTagBaseNames, baseNames...)
+		h.addStrings(rpmTagDirNames, dirNames...)
+		h.addInt32(rpmTagFileDigestAlgo, 8) // sha256
+	}
+
+	header := h.bytes()
+
+	sha1sum := sha1.Sum(header)
+	sha256sum := sha256.Sum256(header)
+	md5sum := md5.New()
+	md5sum.Write(header)
+	md5sum.Write(payload)
+
+	sig := newRPMHeader(rpmSigRegion)
+	sig.addString(rpmSigSHA1, fmt.Sprintf("%x", sha1sum))
+	sig.addString(rpmSigSHA256, fmt.Sprintf("%x", sha256sum))
+	sig.addInt32(rpmSigSize, int32(len(header)+len(payload)))
+	sig.addBinary(rpmSigMD5, md5sum.Sum(nil))
+	sig.addInt32(rpmSigPayloadSize, int32(len(cpio)))
+	signature := sig.bytes()
+	if n := len(signature) % 8; n != 0 {
+		signature = append(signature, make([]byte, 8-n)...)
+	}
+
+	f, err := os.Create(a.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, data := range [][]byte{a.lead(version, release), signature, header, payload} {
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// lead returns the 96 bytes lead of rpm file
+func (a rpmArchive) lead(version, release string) []byte {
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0}) // magic and version 3.0
+	binary.BigEndian.PutUint16(lead[6:], 0)          // binary package
+	binary.BigEndian.PutUint16(lead[8:], 1)          // arch
+	name := fmt.Sprintf("%s-%s-%s", a.name, version, release)
+	if len(name) > 65 {
+		name = name[:65]
+	}
+	copy(lead[10:76], name)
+	binary.BigEndian.PutUint16(lead[76:], 1) // linux
+	binary.BigEndian.PutUint16(lead[78:], 5) // header-style signature
+	return lead
+}
+
+// cpioPayload returns the files in cpio newc format and their sha256 digests
+func (a rpmArchive) cpioPayload(files []packageFile) ([]byte, []string, error) {
+	var buf bytes.Buffer
+	var digests []string
+
+	writeEntry := func(ino int, mode int64, size int64, name string) {
+		fmt.Fprintf(&buf, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			ino, mode, 0, 0, 1, a.modTime.Unix(), size, 0, 0, 0, 0, len(name)+1, 0)
+		buf.WriteString(name)
+		buf.WriteByte(0)
+		padBuffer(&buf, 4)
+	}
+
+	for i, f := range files {
+		writeEntry(i+1, int64(0100000|f.mode), f.size, "."+f.name)
+
+		h := sha256.New()
+		if err := copyFile(io.MultiWriter(&buf, h), f.path); err != nil {
+			return nil, nil, err
+		}
+		padBuffer(&buf, 4)
+		digests = append(digests, fmt.Sprintf("%x", h.Sum(nil)))
+	}
+	writeEntry(0, 0, 0, "TRAILER!!!")
+
+	return buf.Bytes(), digests, nil
+}
+
+// rpmFlags returns the rpm sense flags of dependency
+func (d packageDepend) rpmFlags() int32 {
+	var flags int32
+	if strings.Contains(d.op, "<") {
+		flags |= rpmSenseLess
+	}
+	if strings.Contains(d.op, ">") {
+		flags |= rpmSenseGreater
+	}
+	if strings.Contains(d.op, "=") {
+		flags |= rpmSenseEqual
+	}
+	return flags
+}
+
+// rpmHeader is a header structure used by both signature and header of rpm
+type rpmHeader struct {
+	region  int32
+	entries map[int32]rpmHeaderEntry
+}
+
+type rpmHeaderEntry struct {
+	typ   int32
+	count int32
+	data  []byte
+}
+
+func newRPMHeader(region int32) *rpmHeader {
+	return &rpmHeader{
+		region:  region,
+		entries: map[int32]rpmHeaderEntry{},
+	}
+}
+
+func (h *rpmHeader) addString(tag int32, value string) {
+	h.entries[tag] = rpmHeaderEntry{rpmTypeString, 1, append([]byte(value), 0)}
+}
+
+func (h *rpmHeader) addI18NString(tag int32, value string) {
+	h.entries[tag] = rpmHeaderEntry{rpmTypeI18NString, 1, append([]byte(value), 0)}
+}
+
+func (h *rpmHeader) addStrings(tag int32, values ...string) {
+	var data []byte
+	for _, v := range values {
+		data = append(append(data, v...), 0)
+	}
+	h.entries[tag] = rpmHeaderEntry{rpmTypeStringArray, int32(len(values)), data}
+}
+
+func (h *rpmHeader) addInt32(tag int32, values ...int32) {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(data[4*i:], uint32(v))
+	}
+	h.entries[tag] = rpmHeaderEntry{rpmTypeInt32, int32(len(values)), data}
+}
+
+func (h *rpmHeader) addInt16(tag int32, values ...int16) {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint16(data[2*i:], uint16(v))
+	}
+	h.entries[tag] = rpmHeaderEntry{rpmTypeInt16, int32(len(values)), data}
+}
+
+func (h *rpmHeader) addBinary(tag int32, value []byte) {
+	h.entries[tag] = rpmHeaderEntry{rpmTypeBinary, int32(len(value)), value}
+}
+
+// bytes returns the header structure: magic, index entries sorted by tag
+// and data store, the region tag is the first index entry and its trailer
+// is the last 16 bytes of data store.
+func (h *rpmHeader) bytes() []byte {
+	tags := make([]int, 0, len(h.entries))
+	for tag := range h.entries {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+
+	var store bytes.Buffer
+	offsets := make([]int, len(tags))
+	for i, tag := range tags {
+		e := h.entries[int32(tag)]
+		switch e.typ {
+		case rpmTypeInt16:
+			padBuffer(&store, 2)
+		case rpmTypeInt32:
+			padBuffer(&store, 4)
+		}
+		offsets[i] = store.Len()
+		store.Write(e.data)
+	}
+
+	count := len(tags) + 1
+	regionOffset := store.Len()
+	binary.Write(&store, binary.BigEndian, []int32{h.region, rpmTypeBinary, int32(-16 * count), 16})
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&buf, binary.BigEndian, []int32{int32(count), int32(store.Len())})
+	binary.Write(&buf, binary.BigEndian, []int32{h.region, rpmTypeBinary, int32(regionOffset), 16})
+	for i, tag := range tags {
+		e := h.entries[int32(tag)]
+		binary.Write(&buf, binary.BigEndian, []int32{int32(tag), e.typ, int32(offsets[i]), e.count})
+	}
+	buf.Write(store.Bytes())
+	return buf.Bytes()
+}
+
+// padBuffer pads the buffer with zeros to a multiple of n
+func padBuffer(buf *bytes.Buffer, n int) {
+	if m := buf.Len() % n; m != 0 {
+		buf.Write(make([]byte, n-m))
+	}
+}
+
+// gzipBytes returns the gzip compressed data
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
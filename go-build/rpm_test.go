@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestRPMVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{"1.2.3", "1.2.3"},
+		{"1.2.3-5-gabc1234", "1.2.3^5.gabc1234"},
+		{"1.2.3-5-gabc1234+dirty", "1.2.3^5.gabc1234+dirty"},
+		{"0.0.0-12-gabc1234", "0.0.0^12.gabc1234"},
+		{"1.2.3-rc1", "1.2.3~rc1"},
+		{"1.2.3-rc1+dirty", "1.2.3~rc1+dirty"},
+		{"1.2.3-rc1-5-gabc1234", "1.2.3~rc1^5.gabc1234"},
+		{"1.2.3-beta-2", "1.2.3~beta~2"},
+	}
+	for _, tt := range tests {
+		if got := rpmVersion(tt.version); got != tt.want {
+			t.Errorf("rpmVersion(%q) = %q, want %q", tt.version, got, tt.want)
+		}
+	}
+}
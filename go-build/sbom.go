@@ -0,0 +1,530 @@
+package main
+
+import (
+	"crypto/sha256"
+	"debug/buildinfo"
+	"debug/macho"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	sha256sum "github.com/subchen/go-stack/encoding/sha256"
+	"github.com/subchen/go-stack/fs"
+	"gopkg.in/yaml.v2"
+)
+
+// sbomExts are the supported --sbom formats and the file extensions
+var sbomExts = map[string]string{
+	"spdx":      ".spdx.json",
+	"cyclonedx": ".cdx.json",
+}
+
+// sbomModule is a go module linked into the binary
+type sbomModule struct {
+	path    string
+	version string
+	h1      string // h1: dirhash of the module tree in go.sum, not a checksum of any file
+	license string // SPDX license id or NOASSERTION
+}
+
+func (m sbomModule) purl() string {
+	purl := "pkg:golang/" + m.path
+	if m.version != "" {
+		purl += "@" + m.version
+	}
+	return purl
+}
+
+// sbom describes a binary and the modules linked into it
+type sbom struct {
+	name      string // binary file name
+	version   string
+	sha256    string
+	goVersion string
+	created   time.Time
+	main      sbomModule
+	deps      []sbomModule
+	gopath    bool // built in GOPATH mode, no module info in binary
+}
+
+// checkSBOMFormats returns an error if any --sbom format is not supported
+func checkSBOMFormats(formats []string) error {
+	for _, format := range formats {
+		if _, ok := sbomExts[format]; !ok {
+			return fmt.Errorf("invalid --sbom %s, should be spdx or cyclonedx", format)
+		}
+	}
+	return nil
+}
+
+// writeSBOMs writes the SBOMs of binary built from pkg for target t into output-dir
+// as basename.<ext>, returns the written files, even if failed.
+func writeSBOMs(binary, basename string, pkg mainPackage, t target, settings goSettings, opts buildOptions) ([]string, error) {
+	formats := splitList(sbomFormats)
+	if len(formats) == 0 {
+		return nil, nil
+	}
+
+	s, err := newSBOM(binary, opts.buildTime)
+	if err == nil && s.gopath {
+		env := append(t.archs()[0].env(), settings.env...)
+		s.deps, err = listModules(pkg, settings, env)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate sbom of %s: %v", binary, err)
+	}
+
+	var files []string
+	for _, format := range formats {
+		var doc interface{}
+		if format == "spdx" {
+			doc = s.spdx()
+		} else {
+			doc = s.cyclonedx()
+		}
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return files, err
+		}
+
+		file := filepath.Join(opts.outputDir, basename+sbomExts[format])
+		if err := fs.FileWriteBytes(file, append(data, '\n')); err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// newSBOM reads the build info of binary
+func newSBOM(binary string, created time.Time) (*sbom, error) {
+	info, err := readBuildInfo(binary)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := sha256sum.SumFile(binary)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sbom{
+		name:      filepath.Base(binary),
+		version:   appVersion,
+		sha256:    sum,
+		goVersion: info.GoVersion,
+		created:   created.UTC(),
+		main:      sbomModule{path: info.Main.Path, version: info.Main.Version, license: "NOASSERTION"},
+		gopath:    info.Main.Path == "",
+	}
+	if s.main.path == "" || s.main.path == "command-line-arguments" {
+		s.main.path = appName
+	}
+	if s.main.version == "" || s.main.version == "(devel)" {
+		s.main.version = appVersion
+	}
+	s.main.license = detectLicense(parentDirs(sourceDir)...)
+
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		m := sbomModule{path: dep.Path, version: dep.Version, h1: dep.Sum}
+		m.license = detectLicense(moduleDirs(dep.Path, dep.Version)...)
+		s.deps = append(s.deps, m)
+	}
+	return s, nil
+}
+
+// readBuildInfo reads the build info of binary, the first arch of a fat Mach-O binary is read
+func readBuildInfo(binary string) (*buildinfo.BuildInfo, error) {
+	fat, err := macho.OpenFat(binary)
+	if err != nil {
+		return buildinfo.ReadFile(binary)
+	}
+	defer fat.Close()
+
+	f, err := os.Open(binary)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	arch := fat.Arches[0]
+	return buildinfo.Read(io.NewSectionReader(f, int64(arch.Offset), int64(arch.Size)))
+}
+
+var (
+	modCacheOnce sync.Once
+	modCache     string
+)
+
+// moduleDirs returns the dirs of module in vendor and module cache
+func moduleDirs(path, version string) []string {
+	modCacheOnce.Do(func() {
+		out, _, _ := newCommand("go", "env", "GOMODCACHE").Dir(sourceDir).Output()
+		modCache = strings.TrimSpace(out)
+	})
+
+	dirs := []string{filepath.Join(sourceDir, "vendor", filepath.FromSlash(path))}
+	if modCache != "" {
+		// upper case letters are escaped as !lower in module cache
+		var escaped strings.Builder
+		for _, r := range path + "@" + version {
+			if unicode.IsUpper(r) {
+				escaped.WriteRune('!')
+				r = unicode.ToLower(r)
+			}
+			escaped.WriteRune(r)
+		}
+		dirs = append(dirs, filepath.Join(modCache, filepath.FromSlash(escaped.String())))
+	}
+	return dirs
+}
+
+// glideLock is the imports pinned in glide.lock
+type glideLock struct {
+	Imports []struct {
+		Name    string `yaml:"name"`
+		Version string `yaml:"version"`
+	} `yaml:"imports"`
+}
+
+// readGlideLock reads glide.lock next to rootDir if rootDir is a vendor dir of glide,
+// returns an empty lock for GOPATH/src and the vendor dirs of other tools.
+func readGlideLock(rootDir string) (glideLock, error) {
+	var lock glideLock
+	lockFile := filepath.Join(filepath.Dir(rootDir), "glide.lock")
+	if filepath.Base(rootDir) != "vendor" || !fs.IsFile(lockFile) {
+		return lock, nil
+	}
+	data, err := ioutil.ReadFile(lockFile)
+	if err == nil {
+		err = yaml.Unmarshal(data, &lock)
+	}
+	if err != nil {
+		return lock, fmt.Errorf("invalid %s: %v", lockFile, err)
+	}
+	return lock, nil
+}
+
+// listModules returns the non-standard dependencies of pkg grouped by repo, it is used
+// if the binary has no module info, i.e. built in GOPATH mode.
+// The versions are only known for a vendor dir of glide, read from its glide.lock,
+// other dependencies are listed by the repo root without version.
+func listModules(pkg mainPackage, settings goSettings, env []string) ([]sbomModule, error) {
+	args := []string{"list", "-deps", "-json"}
+	if len(settings.tags) > 0 {
+		args = append(args, "-tags", strings.Join(settings.tags, ","))
+	}
+	if pkg.importPath == "" {
+		args = append(args, ".")
+	} else {
+		args = append(args, pkg.importPath)
+	}
+
+	out, _, err := newCommand("go", args...).Dir(sourceDir).Env(env...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list dependencies: %v", err)
+	}
+	srcDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var modules []sbomModule
+	seen := map[string]bool{}
+	locks := map[string]glideLock{}
+	dec := json.NewDecoder(strings.NewReader(out))
+	for dec.More() {
+		var p listedPackage
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("unable to list dependencies: %v", err)
+		}
+
+		path := p.ImportPath
+		if i := strings.LastIndex(path, "/vendor/"); i >= 0 {
+			path = path[i+len("/vendor/"):]
+		} else if p.Standard || p.Dir == srcDir || strings.HasPrefix(p.Dir, srcDir+string(filepath.Separator)) {
+			continue // standard or in source-dir
+		}
+		// the dir containing the package by import path, e.g. vendor or GOPATH/src
+		rootDir := filepath.Clean(strings.TrimSuffix(p.Dir, filepath.FromSlash(path)))
+
+		lock, ok := locks[rootDir]
+		if !ok {
+			if lock, err = readGlideLock(rootDir); err != nil {
+				return nil, err
+			}
+			locks[rootDir] = lock
+		}
+
+		m := sbomModule{path: repoRoot(rootDir, path)}
+		for _, imp := range lock.Imports {
+			if path == imp.Name || strings.HasPrefix(path, imp.Name+"/") {
+				m.path, m.version = imp.Name, imp.Version
+				break
+			}
+		}
+		if seen[m.path] {
+			continue
+		}
+		seen[m.path] = true
+		m.license = detectLicense(append([]string{filepath.Join(rootDir, filepath.FromSlash(m.path))}, moduleDirs(m.path, m.version)...)...)
+		modules = append(modules, m)
+	}
+	return modules, nil
+}
+
+// repoRoot returns the top-most parent of package path in rootDir having a license file,
+// e.g. github.com/user/repo for github.com/user/repo/sub, or path if none.
+func repoRoot(rootDir, path string) string {
+	root := path
+	for p := path; p != "." && p != "/"; p = filepath.ToSlash(filepath.Dir(p)) {
+		if len(licenseFiles(filepath.Join(rootDir, filepath.FromSlash(p)))) > 0 {
+			root = p
+		}
+	}
+	return root
+}
+
+// licensePatterns identify the common licenses, checked in order
+var licensePatterns = []struct {
+	id       string
+	patterns []string
+}{
+	{"Apache-2.0", []string{"Apache License", "Version 2.0"}},
+	{"MPL-2.0", []string{"Mozilla Public License", "2.0"}},
+	{"AGPL-3.0", []string{"GNU AFFERO GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"LGPL-3.0", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-3.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-2.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 2"}},
+	{"MIT", []string{"Permission is hereby granted, free of charge"}},
+	{"ISC", []string{"Permission to use, copy, modify, and/or distribute this software for any"}},
+	{"BSD-3-Clause", []string{"Redistribution and use in source and binary forms", "Neither the name"}},
+	{"BSD-2-Clause", []string{"Redistribution and use in source and binary forms"}},
+}
+
+// detectLicense returns the SPDX id of the license file in the first dir having one
+func detectLicense(dirs ...string) string {
+	for _, dir := range dirs {
+		files := licenseFiles(dir)
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			text := strings.Join(strings.Fields(string(data)), " ")
+			for _, l := range licensePatterns {
+				matched := true
+				for _, p := range l.patterns {
+					matched = matched && strings.Contains(text, p)
+				}
+				if matched {
+					return l.id
+				}
+			}
+		}
+		if len(files) > 0 {
+			return "NOASSERTION"
+		}
+	}
+	return "NOASSERTION"
+}
+
+// licenseFiles returns the LICENSE, LICENCE or COPYING files in dir
+func licenseFiles(dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var licenses []string
+	for _, file := range files {
+		name := strings.ToUpper(file.Name())
+		if !file.IsDir() && (strings.HasPrefix(name, "LICENSE") || strings.HasPrefix(name, "LICENCE") || strings.HasPrefix(name, "COPYING")) {
+			licenses = append(licenses, filepath.Join(dir, file.Name()))
+		}
+	}
+	return licenses
+}
+
+// parentDirs returns dir and its parents up to the root of git repo or file system
+func parentDirs(dir string) []string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return []string{dir}
+	}
+	var dirs []string
+	for {
+		dirs = append(dirs, dir)
+		parent := filepath.Dir(dir)
+		if parent == dir || fs.Exists(filepath.Join(dir, ".git")) {
+			return dirs
+		}
+		dir = parent
+	}
+}
+
+// uuid returns a name based uuid of the sbom
+func (s *sbom) uuid() string {
+	h := sha256.Sum256([]byte(s.name + "@" + s.sha256))
+	h[6] = h[6]&0x0f | 0x50 // version 5
+	h[8] = h[8]&0x3f | 0x80 // variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// spdx returns the SPDX 2.3 JSON document
+func (s *sbom) spdx() interface{} {
+	type checksum struct {
+		Algorithm     string `json:"algorithm"`
+		ChecksumValue string `json:"checksumValue"`
+	}
+	type annotation struct {
+		AnnotationType string `json:"annotationType"`
+		Annotator      string `json:"annotator"`
+		AnnotationDate string `json:"annotationDate"`
+		Comment        string `json:"comment"`
+	}
+	type externalRef struct {
+		ReferenceCategory string `json:"referenceCategory"`
+		ReferenceType     string `json:"referenceType"`
+		ReferenceLocator  string `json:"referenceLocator"`
+	}
+	type pkg struct {
+		Name             string        `json:"name"`
+		SPDXID           string        `json:"SPDXID"`
+		VersionInfo      string        `json:"versionInfo,omitempty"`
+		DownloadLocation string        `json:"downloadLocation"`
+		FilesAnalyzed    bool          `json:"filesAnalyzed"`
+		LicenseConcluded string        `json:"licenseConcluded"`
+		LicenseDeclared  string        `json:"licenseDeclared"`
+		Checksums        []checksum    `json:"checksums,omitempty"`
+		ExternalRefs     []externalRef `json:"externalRefs,omitempty"`
+		Annotations      []annotation  `json:"annotations,omitempty"`
+	}
+	type relationship struct {
+		SpdxElementID      string `json:"spdxElementId"`
+		RelationshipType   string `json:"relationshipType"`
+		RelatedSpdxElement string `json:"relatedSpdxElement"`
+	}
+
+	newPackage := func(id string, m sbomModule) pkg {
+		p := pkg{
+			Name:             m.path,
+			SPDXID:           id,
+			VersionInfo:      m.version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  m.license,
+			ExternalRefs:     []externalRef{{"PACKAGE-MANAGER", "purl", m.purl()}},
+		}
+		if m.h1 != "" {
+			p.Annotations = []annotation{{"OTHER", "Tool: go-build", s.created.Format(time.RFC3339), "go-module-h1: " + m.h1}}
+		}
+		return p
+	}
+
+	binary := newPackage("SPDXRef-Binary", sbomModule{path: s.name, version: s.version, license: s.main.license})
+	binary.Checksums = []checksum{{"SHA256", s.sha256}}
+	binary.ExternalRefs = nil
+	packages := []pkg{binary, newPackage("SPDXRef-Module-0", s.main)}
+	relationships := []relationship{
+		{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-Binary"},
+		{"SPDXRef-Binary", "GENERATED_FROM", "SPDXRef-Module-0"},
+	}
+	stdlib := newPackage("SPDXRef-Stdlib", sbomModule{path: "stdlib", version: s.goVersion, license: "BSD-3-Clause"})
+	packages = append(packages, stdlib)
+	relationships = append(relationships, relationship{"SPDXRef-Module-0", "DEPENDS_ON", "SPDXRef-Stdlib"})
+	for i, m := range s.deps {
+		id := fmt.Sprintf("SPDXRef-Module-%d", i+1)
+		packages = append(packages, newPackage(id, m))
+		relationships = append(relationships, relationship{"SPDXRef-Module-0", "DEPENDS_ON", id})
+	}
+
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              s.name,
+		"documentNamespace": "https://spdx.org/spdxdocs/" + s.name + "-" + s.uuid(),
+		"creationInfo": map[string]interface{}{
+			"created":  s.created.Format(time.RFC3339),
+			"creators": []string{"Tool: go-build"},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
+
+// cyclonedx returns the CycloneDX 1.5 JSON document
+func (s *sbom) cyclonedx() interface{} {
+	type hash struct {
+		Alg     string `json:"alg"`
+		Content string `json:"content"`
+	}
+	type license struct {
+		License map[string]string `json:"license"`
+	}
+	type property struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	type component struct {
+		Type       string     `json:"type"`
+		BomRef     string     `json:"bom-ref"`
+		Name       string     `json:"name"`
+		Version    string     `json:"version,omitempty"`
+		Purl       string     `json:"purl,omitempty"`
+		Hashes     []hash     `json:"hashes,omitempty"`
+		Licenses   []license  `json:"licenses,omitempty"`
+		Properties []property `json:"properties,omitempty"`
+	}
+	type dependency struct {
+		Ref       string   `json:"ref"`
+		DependsOn []string `json:"dependsOn"`
+	}
+
+	newComponent := func(typ string, m sbomModule) component {
+		c := component{Type: typ, BomRef: m.purl(), Name: m.path, Version: m.version, Purl: m.purl()}
+		if m.license != "" && m.license != "NOASSERTION" {
+			c.Licenses = []license{{map[string]string{"id": m.license}}}
+		}
+		if m.h1 != "" {
+			c.Properties = []property{{"go-module-h1", m.h1}}
+		}
+		return c
+	}
+
+	app := newComponent("application", s.main)
+	app.Hashes = []hash{{"SHA-256", s.sha256}}
+	stdlib := newComponent("library", sbomModule{path: "stdlib", version: s.goVersion, license: "BSD-3-Clause"})
+	components := []component{stdlib}
+	dependsOn := []string{stdlib.BomRef}
+	dependencies := []dependency{{Ref: stdlib.BomRef, DependsOn: []string{}}}
+	for _, m := range s.deps {
+		c := newComponent("library", m)
+		components = append(components, c)
+		dependsOn = append(dependsOn, c.BomRef)
+		dependencies = append(dependencies, dependency{Ref: c.BomRef, DependsOn: []string{}})
+	}
+	dependencies = append([]dependency{{Ref: app.BomRef, DependsOn: dependsOn}}, dependencies...)
+
+	return map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + s.uuid(),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": s.created.Format(time.RFC3339),
+			"tools":     []map[string]string{{"name": "go-build"}},
+			"component": app,
+		},
+		"components":   components,
+		"dependencies": dependencies,
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// target is a GOOS/GOARCH pair to build, with an optional arch variant
+type target struct {
+	goos    string
+	goarch  string
+	variant string // e.g. v7 for arm, v3 for amd64, softfloat for mips
+}
+
+func (t target) String() string {
+	s := t.goos + "/" + t.goarch
+	if t.variant != "" {
+		s += "/" + t.variant
+	}
+	return s
+}
+
+// variantEnvs maps GOARCH to the env var of arch variant
+var variantEnvs = map[string]string{
+	"arm":      "GOARM",
+	"amd64":    "GOAMD64",
+	"386":      "GO386",
+	"mips":     "GOMIPS",
+	"mipsle":   "GOMIPS",
+	"mips64":   "GOMIPS64",
+	"mips64le": "GOMIPS64",
+}
+
+// variantValues are the allowed variants of each arch
+var variantValues = map[string][]string{
+	"arm":      {"v5", "v6", "v7"},
+	"amd64":    {"v1", "v2", "v3", "v4"},
+	"386":      {"sse2", "softfloat"},
+	"mips":     {"hardfloat", "softfloat"},
+	"mipsle":   {"hardfloat", "softfloat"},
+	"mips64":   {"hardfloat", "softfloat"},
+	"mips64le": {"hardfloat", "softfloat"},
+}
+
+// env returns the env vars of the target for go build
+func (t target) env() []string {
+	env := []string{"GOOS=" + t.goos, "GOARCH=" + t.goarch}
+	if t.variant != "" {
+		value := t.variant
+		if t.goarch == "arm" {
+			value = strings.TrimPrefix(value, "v") // GOARM=7
+		}
+		env = append(env, variantEnvs[t.goarch]+"="+value)
+	}
+	return env
+}
+
+// goarm returns the GOARM of arm target
+func (t target) goarm() string {
+	if t.goarch != "arm" {
+		return ""
+	}
+	return strings.TrimPrefix(t.variant, "v")
+}
+
+// parseTarget parses a target in the form of goos/goarch[/variant],
+// a GOARM is accepted as variant with or without `v` prefix.
+func parseTarget(spec string) (target, error) {
+	parts := strings.Split(strings.TrimSpace(spec), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return target{}, fmt.Errorf("invalid target %s, should be goos/goarch[/variant]", spec)
+	}
+
+	t := target{goos: parts[0], goarch: parts[1]}
+	if len(parts) == 3 {
+		t.variant = parts[2]
+		if t.goarch == "arm" && !strings.HasPrefix(t.variant, "v") {
+			t.variant = "v" + t.variant
+		}
+
+		valid := false
+		for _, v := range variantValues[t.goarch] {
+			valid = valid || v == t.variant
+		}
+		if !valid {
+			return target{}, fmt.Errorf("invalid target %s, variant of %s should be one of: %s", spec, t.goarch, strings.Join(variantValues[t.goarch], ", "))
+		}
+	}
+	return t, nil
+}
+
+// resolveTargets returns the targets from --target, or the cross product of
+// --goos and --goarch, excluding --ignore entries. An ignore entry without
+// variant excludes all variants of the goos/goarch.
+func resolveTargets(specs, ignores []string) ([]target, error) {
+	var targets []target
+	if len(specs) > 0 {
+		for _, spec := range specs {
+			for _, s := range strings.Split(spec, ",") {
+				t, err := parseTarget(s)
+				if err != nil {
+					return nil, err
+				}
+				targets = append(targets, t)
+			}
+		}
+	} else {
+		for _, goos := range strings.Split(goos, ",") {
+			for _, goarch := range strings.Split(goarch, ",") {
+				t, err := parseTarget(strings.TrimSpace(goos) + "/" + strings.TrimSpace(goarch))
+				if err != nil {
+					return nil, err
+				}
+				targets = append(targets, t)
+			}
+		}
+	}
+
+	var ignored []target
+	for _, spec := range ignores {
+		for _, s := range strings.Split(spec, ",") {
+			t, err := parseTarget(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --ignore: %v", err)
+			}
+			ignored = append(ignored, t)
+		}
+	}
+
+	var result []target
+	seen := map[target]bool{}
+	for _, t := range targets {
+		skip := seen[t]
+		for _, i := range ignored {
+			if i.goos == t.goos && i.goarch == t.goarch && (i.variant == "" || i.variant == t.variant) {
+				skip = true
+			}
+		}
+		if !skip {
+			seen[t] = true
+			result = append(result, t)
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no targets to build")
+	}
+	return result, nil
+}
+
+// validateTargets returns an error if any target is not supported by `go tool dist list`
+func validateTargets(targets []target) error {
+	out, _, err := newCommand("go", "tool", "dist", "list").Output()
+	if err != nil {
+		return fmt.Errorf("unable to list supported targets: %v", err)
+	}
+
+	supported := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		supported[strings.TrimSpace(line)] = true
+	}
+
+	var invalid []string
+	for _, t := range targets {
+		if t.goarch == universalArch && t.goos != "darwin" {
+			invalid = append(invalid, t.String())
+			continue
+		}
+		for _, arch := range t.archs() {
+			if !supported[arch.goos+"/"+arch.goarch] {
+				invalid = append(invalid, t.String())
+				break
+			}
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("unsupported targets: %s, exclude them by --ignore", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// lookupTargetConfig returns the per-target overrides for goos/goarch/variant,
+// or goos/goarch if no variant specific config.
+func lookupTargetConfig(t target) (targetConfig, bool) {
+	if tc, ok := targetConfigs[t.String()]; ok {
+		return tc, true
+	}
+	tc, ok := targetConfigs[t.goos+"/"+t.goarch]
+	return tc, ok
+}
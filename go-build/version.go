@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// gitVersion computes a semver from `git describe --tags` in dir
+//
+//	v1.2.3 at HEAD              => 1.2.3
+//	5 commits after v1.2.3      => 1.2.3-5-gabc1234
+//	uncommitted changes         => 1.2.3+dirty
+//	no tags                     => 0.0.0-<commits>-gabc1234
+func gitVersion(dir string) (string, error) {
+	desc, err := gitOutput(dir, "describe", "--tags", "--long", "--dirty", "--abbrev=7")
+	if err != nil {
+		// no tags found, count from the first commit
+		count, err := gitOutput(dir, "rev-list", "HEAD", "--count")
+		if err != nil {
+			return "", fmt.Errorf("unable to get version from git: %v", err)
+		}
+		sha, err := gitOutput(dir, "describe", "--always", "--dirty", "--abbrev=7")
+		if err != nil {
+			return "", fmt.Errorf("unable to get version from git: %v", err)
+		}
+		desc = "v0.0.0-" + count + "-g" + sha
+	}
+
+	dirty := strings.HasSuffix(desc, "-dirty")
+	desc = strings.TrimSuffix(desc, "-dirty")
+
+	// <tag>-<commits>-g<sha>
+	parts := strings.Split(desc, "-")
+	if len(parts) < 3 {
+		return "", fmt.Errorf("unable to get version from git: unexpected describe output %s", desc)
+	}
+	tag := strings.Join(parts[:len(parts)-2], "-")
+	commits := parts[len(parts)-2]
+	sha := parts[len(parts)-1]
+
+	version := strings.TrimPrefix(tag, "v")
+	if commits != "0" {
+		version += "-" + commits + "-" + sha
+	}
+	if dirty {
+		version += "+dirty"
+	}
+	return version, nil
+}
+
+// gitOutput runs git in dir and returns the trimmed stdout
+func gitOutput(dir string, args ...string) (string, error) {
+	out, _, err := newCommand("git", args...).Dir(dir).Output()
+	return strings.TrimSpace(out), err
+}
+
+// gitAuthor returns `name <email>` of the last commit author in dir,
+// or of the configured git user if there is no commit
+func gitAuthor(dir string) (string, error) {
+	author, err := gitOutput(dir, "log", "-1", "--format=%an <%ae>")
+	if err == nil && author != "" {
+		return author, nil
+	}
+	name, err := gitOutput(dir, "config", "user.name")
+	if err != nil || name == "" {
+		return "", fmt.Errorf("unable to get author from git: %v", err)
+	}
+	email, _ := gitOutput(dir, "config", "user.email")
+	if email == "" {
+		return name, nil
+	}
+	return name + " <" + email + ">", nil
+}
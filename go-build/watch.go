@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// watch flags
+var (
+	watchRun      string
+	watchInterval time.Duration
+	watchDebounce time.Duration
+)
+
+// sourceSnapshot is the size and mtime of watched files
+type sourceSnapshot map[string]string
+
+// watch builds the host target, then rebuilds it and restarts --run command
+// each time the sources changed, until interrupted or an usageError occurred,
+// the --run command is killed before return.
+func watch() error {
+	// binaries only, no archives for local development
+	targetSpecs = []string{runtime.GOOS + "/" + runtime.GOARCH}
+	ignoreSpecs = nil
+	archiveFmt = ""
+	bundle = false
+	for name, tc := range targetConfigs {
+		tc.Archive = ""
+		targetConfigs[name] = tc
+	}
+
+	if watchInterval <= 0 {
+		watchInterval = 500 * time.Millisecond
+	}
+
+	var stop func()
+	snapshot := scanSources()
+	for {
+		results, err := gobuild()
+		if _, ok := err.(usageError); ok || err == errInterrupted {
+			if stop != nil {
+				stop()
+			}
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		} else if watchRun != "" {
+			if stop != nil {
+				stop()
+			}
+			stop = startRun(results)
+		}
+
+		fmt.Printf("watch: waiting for changes in %s ...\n", sourceDir)
+		snapshot = waitForChanges(snapshot)
+		if interrupted.Err() != nil {
+			if stop != nil {
+				stop()
+			}
+			return errInterrupted
+		}
+	}
+}
+
+// waitForChanges polls the sources until they changed and then stay unchanged
+// for --debounce, returns the latest snapshot, or last if interrupted.
+func waitForChanges(last sourceSnapshot) sourceSnapshot {
+	for {
+		select {
+		case <-interrupted.Done():
+			return last
+		case <-time.After(watchInterval):
+		}
+		current := scanSources()
+		if changed := diffSources(last, current); len(changed) > 0 {
+			fmt.Printf("watch: changed %s\n", strings.Join(changed, ", "))
+			return debounce(current)
+		}
+	}
+}
+
+// debounce waits until the sources are not changed for --debounce
+func debounce(last sourceSnapshot) sourceSnapshot {
+	quiet := time.Duration(0)
+	for quiet < watchDebounce {
+		step := watchInterval
+		if step > watchDebounce-quiet {
+			step = watchDebounce - quiet
+		}
+		time.Sleep(step)
+
+		current := scanSources()
+		if len(diffSources(last, current)) > 0 {
+			quiet = 0
+		} else {
+			quiet += step
+		}
+		last = current
+	}
+	return last
+}
+
+// scanSources returns the snapshot of .go files (excluding tests), go.mod and go.sum
+// in source-dir, the hidden dirs and output-dir are skipped.
+func scanSources() sourceSnapshot {
+	output, _ := filepath.Abs(outputDir)
+	snapshot := sourceSnapshot{}
+	filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // deleted while walking
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if path != sourceDir && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+			if abs, _ := filepath.Abs(path); abs == output {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if name == "go.mod" || name == "go.sum" || (strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go")) {
+			snapshot[path] = fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return snapshot
+}
+
+// diffSources returns the files added, modified or removed
+func diffSources(last, current sourceSnapshot) []string {
+	var changed []string
+	for path, stat := range current {
+		if last[path] != stat {
+			changed = append(changed, path)
+		}
+	}
+	for path := range last {
+		if _, ok := current[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	return changed
+}
+
+// startRun starts --run command in background with the built binaries,
+// returns a func to kill it with the processes it started and wait for its exit.
+func startRun(results []buildResult) func() {
+	var binaries []string
+	for _, r := range results {
+		for _, a := range r.artifacts {
+			binaries = append(binaries, filepath.Join(outputDir, a.Path))
+		}
+	}
+	env := append(hookEnv(outputDir), "BUILD_OUTPUT="+strings.Join(binaries, " "))
+
+	c := newCommand("/bin/sh", "-c", watchRun)
+	if runtime.GOOS == "windows" {
+		c = newCommand("cmd", "/C", watchRun)
+	}
+
+	ctx, cancel := context.WithCancel(interrupted)
+	done := make(chan struct{})
+	fmt.Printf("watch: run %s\n", watchRun)
+	go func() {
+		defer close(done)
+		err := c.Dir(sourceDir).Env(env...).Context(ctx).ProcessGroup().Run()
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "watch: `%s` exited: %v\n", watchRun, err)
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf16"
+)
+
+// sysoFilePattern is the .syso file written into the package dir during go build
+// and removed after, it can be added into .gitignore: zz_go_build_windows_*.syso
+const sysoFilePattern = "zz_go_build_windows_%s.syso"
+
+// winResource is the version info and icon embedded into windows binaries
+// by a .syso object file, which is linked by go build automatically.
+type winResource struct {
+	name        string // internal name, the package name
+	filename    string // original file name of the binary
+	company     string
+	description string
+	copyright   string
+	icon        string // .ico file, relative to source-dir
+}
+
+// resource types and language
+const (
+	rtIcon      = 3
+	rtGroupIcon = 14
+	rtVersion   = 16
+	langEnUS    = 0x0409
+	codePageU16 = 0x04b0
+)
+
+// coff machine and ADDR32NB relocation type of GOARCH
+var coffMachines = map[string]struct {
+	machine uint16
+	reloc   uint16
+}{
+	"386":   {0x014c, 0x0007},
+	"amd64": {0x8664, 0x0003},
+	"arm":   {0x01c4, 0x0002},
+	"arm64": {0xaa64, 0x0002},
+}
+
+// newWinResource returns the resource of a binary, nil if --win-resource is not set
+func newWinResource(t target, name, filename string) *winResource {
+	if !winRes || t.goos != "windows" {
+		return nil
+	}
+	r := &winResource{
+		name:        name,
+		filename:    filename,
+		company:     winCompany,
+		description: winDescription,
+		copyright:   winCopyright,
+		icon:        winIcon,
+	}
+	if r.description == "" {
+		r.description = appName
+	}
+	return r
+}
+
+// writeSyso writes the .syso file into dir for the target,
+// returns the file to be removed after go build.
+func (r *winResource) writeSyso(dir string, t target) (string, error) {
+	m, ok := coffMachines[t.goarch]
+	if !ok {
+		return "", fmt.Errorf("windows resource is not supported for %s", t.goarch)
+	}
+
+	resources, err := r.resources()
+	if err != nil {
+		return "", err
+	}
+	data, relocs := buildResourceSection(resources)
+
+	// go build links *_windows_<arch>.syso only for the arch, the builds of
+	// variants are serialized by lockPackageDir, so one file per arch is enough.
+	// an existing file is not overwritten, it may be left by a crashed build
+	// and would be linked by plain go build, or be a file of user.
+	file := filepath.Join(dir, fmt.Sprintf(sysoFilePattern, t.goarch))
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return "", fmt.Errorf("%s already exists, remove it if it is left by an interrupted go-build", file)
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(coffObject(m.machine, m.reloc, data, relocs))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return file, err
+}
+
+// resource is an entry in the resource directory
+type resource struct {
+	typ  uint32
+	id   uint32
+	data []byte
+}
+
+// resources returns the version info and icons
+func (r *winResource) resources() ([]resource, error) {
+	resources := []resource{{typ: rtVersion, id: 1, data: r.versionInfo()}}
+	if r.icon == "" {
+		return resources, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(sourceDir, r.icon))
+	if err != nil {
+		return nil, err
+	}
+	icons, group, err := parseIcon(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --win-icon %s: %v", r.icon, err)
+	}
+	for i, icon := range icons {
+		resources = append(resources, resource{typ: rtIcon, id: uint32(i + 1), data: icon})
+	}
+	return append(resources, resource{typ: rtGroupIcon, id: 1, data: group}), nil
+}
+
+var winVersionRegexp = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:\.(\d+))?`)
+
+// versionInfo returns the VS_VERSIONINFO resource
+func (r *winResource) versionInfo() []byte {
+	// numeric version from the leading digits of --app-version, e.g. 1.2.3-rc1 => 1.2.3.0
+	var v [4]uint16
+	if m := winVersionRegexp.FindStringSubmatch(appVersion); m != nil {
+		for i := range v {
+			n, _ := strconv.ParseUint(m[i+1], 10, 16)
+			v[i] = uint16(n)
+		}
+	}
+	ms := uint32(v[0])<<16 | uint32(v[1])
+	ls := uint32(v[2])<<16 | uint32(v[3])
+
+	fixed := new(bytes.Buffer)
+	binary.Write(fixed, binary.LittleEndian, []uint32{
+		0xfeef04bd, // signature
+		0x00010000, // struct version
+		ms,         // file version MS
+		ls,         // file version LS
+		ms,         // product version MS
+		ls,         // product version LS
+		0x3f,       // file flags mask
+		0,          // file flags
+		0x00040004, // VOS_NT_WINDOWS32
+		1,          // VFT_APP
+		0,          // file subtype
+		0,          // file date MS
+		0,          // file date LS
+	})
+
+	strs := [][2]string{
+		{"CompanyName", r.company},
+		{"FileDescription", r.description},
+		{"FileVersion", appVersion},
+		{"InternalName", r.name},
+		{"LegalCopyright", r.copyright},
+		{"OriginalFilename", r.filename},
+		{"ProductName", appName},
+		{"ProductVersion", appVersion},
+	}
+	var children [][]byte
+	for _, s := range strs {
+		if s[1] != "" {
+			value := utf16z(s[1])
+			children = append(children, versionNode(s[0], 1, value, uint16(len(value)/2)))
+		}
+	}
+	table := versionNode(fmt.Sprintf("%04x%04x", langEnUS, codePageU16), 1, nil, 0, children...)
+	stringInfo := versionNode("StringFileInfo", 1, nil, 0, table)
+
+	translation := make([]byte, 4)
+	binary.LittleEndian.PutUint16(translation, langEnUS)
+	binary.LittleEndian.PutUint16(translation[2:], codePageU16)
+	varInfo := versionNode("VarFileInfo", 1, nil, 0, versionNode("Translation", 0, translation, 4))
+
+	return versionNode("VS_VERSION_INFO", 0, fixed.Bytes(), uint16(fixed.Len()), stringInfo, varInfo)
+}
+
+// versionNode returns a node of VS_VERSIONINFO: length, value length, type, key, value and children,
+// all aligned to 4 bytes.
+func versionNode(key string, typ uint16, value []byte, valueLength uint16, children ...[]byte) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, []uint16{0, valueLength, typ})
+	buf.Write(utf16z(key))
+	pad4(buf)
+	buf.Write(value)
+	for _, child := range children {
+		pad4(buf)
+		buf.Write(child)
+	}
+
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint16(data, uint16(len(data)))
+	return data
+}
+
+// parseIcon returns the images and the GRPICONDIR of an .ico file
+func parseIcon(data []byte) ([][]byte, []byte, error) {
+	if len(data) < 6 || binary.LittleEndian.Uint16(data[2:]) != 1 {
+		return nil, nil, fmt.Errorf("not an icon file")
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if count == 0 || len(data) < 6+16*count {
+		return nil, nil, fmt.Errorf("no images in icon file")
+	}
+
+	var images [][]byte
+	group := new(bytes.Buffer)
+	binary.Write(group, binary.LittleEndian, []uint16{0, 1, uint16(count)})
+	for i := 0; i < count; i++ {
+		entry := data[6+16*i : 6+16*(i+1)]
+		size := binary.LittleEndian.Uint32(entry[8:])
+		offset := binary.LittleEndian.Uint32(entry[12:])
+		if uint64(offset)+uint64(size) > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("image %d is out of file", i)
+		}
+		images = append(images, data[offset:offset+size])
+
+		// GRPICONDIRENTRY is ICONDIRENTRY with the image offset replaced by resource id
+		group.Write(entry[:12])
+		binary.Write(group, binary.LittleEndian, uint16(i+1))
+	}
+	return images, group.Bytes(), nil
+}
+
+// buildResourceSection returns the .rsrc section of resources:
+// the type, id and language directories, data entries and data.
+// The data entries are addressed by RVA, returns the offsets to be relocated.
+func buildResourceSection(resources []resource) ([]byte, []uint32) {
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].typ != resources[j].typ {
+			return resources[i].typ < resources[j].typ
+		}
+		return resources[i].id < resources[j].id
+	})
+
+	var types []uint32
+	ids := map[uint32][]resource{}
+	for _, r := range resources {
+		if len(ids[r.typ]) == 0 {
+			types = append(types, r.typ)
+		}
+		ids[r.typ] = append(ids[r.typ], r)
+	}
+
+	// layout: type dir, id dirs, language dirs, data entries, data
+	dirSize := func(n int) uint32 { return uint32(16 + 8*n) }
+	offset := dirSize(len(types))
+	idDirs := map[uint32]uint32{}
+	for _, typ := range types {
+		idDirs[typ] = offset
+		offset += dirSize(len(ids[typ]))
+	}
+	langDirs := make([]uint32, len(resources))
+	for i := range resources {
+		langDirs[i] = offset
+		offset += dirSize(1)
+	}
+	entries := make([]uint32, len(resources))
+	for i := range resources {
+		entries[i] = offset
+		offset += 16
+	}
+	datas := make([]uint32, len(resources))
+	for i, r := range resources {
+		offset = (offset + 7) &^ 7
+		datas[i] = offset
+		offset += uint32(len(r.data))
+	}
+
+	buf := new(bytes.Buffer)
+	writeDir := func(entries [][2]uint32) {
+		binary.Write(buf, binary.LittleEndian, []uint32{0, 0, 0})
+		binary.Write(buf, binary.LittleEndian, []uint16{0, uint16(len(entries))})
+		for _, e := range entries {
+			binary.Write(buf, binary.LittleEndian, e)
+		}
+	}
+
+	var typeEntries [][2]uint32
+	for _, typ := range types {
+		typeEntries = append(typeEntries, [2]uint32{typ, idDirs[typ] | 0x80000000})
+	}
+	writeDir(typeEntries)
+
+	i := 0
+	for _, typ := range types {
+		var idEntries [][2]uint32
+		for _, r := range ids[typ] {
+			idEntries = append(idEntries, [2]uint32{r.id, langDirs[i] | 0x80000000})
+			i++
+		}
+		writeDir(idEntries)
+	}
+	for i := range resources {
+		writeDir([][2]uint32{{langEnUS, entries[i]}})
+	}
+
+	var relocs []uint32
+	for i, r := range resources {
+		relocs = append(relocs, uint32(buf.Len()))
+		binary.Write(buf, binary.LittleEndian, []uint32{datas[i], uint32(len(r.data)), 0, 0})
+	}
+	for i, r := range resources {
+		buf.Write(make([]byte, int(datas[i])-buf.Len()))
+		buf.Write(r.data)
+	}
+	pad4(buf)
+	return buf.Bytes(), relocs
+}
+
+// coffObject returns a COFF object file with the .rsrc section,
+// the relocations are relative to the section symbol.
+func coffObject(machine, relocType uint16, data []byte, relocs []uint32) []byte {
+	const (
+		fileHeaderSize    = 20
+		sectionHeaderSize = 40
+		relocSize         = 10
+	)
+	dataOffset := uint32(fileHeaderSize + sectionHeaderSize)
+	relocOffset := dataOffset + uint32(len(data))
+	symbolOffset := relocOffset + uint32(relocSize*len(relocs))
+
+	buf := new(bytes.Buffer)
+	le := binary.LittleEndian
+
+	// file header
+	binary.Write(buf, le, machine)
+	binary.Write(buf, le, uint16(1)) // number of sections
+	binary.Write(buf, le, uint32(0)) // timestamp
+	binary.Write(buf, le, symbolOffset)
+	binary.Write(buf, le, uint32(1)) // number of symbols
+	binary.Write(buf, le, uint16(0)) // size of optional header
+	binary.Write(buf, le, uint16(0)) // characteristics
+
+	// section header
+	buf.WriteString(".rsrc\x00\x00\x00")
+	binary.Write(buf, le, []uint32{0, 0, uint32(len(data)), dataOffset, relocOffset, 0})
+	binary.Write(buf, le, []uint16{uint16(len(relocs)), 0})
+	binary.Write(buf, le, uint32(0x40000040)) // initialized data, readable
+
+	buf.Write(data)
+
+	for _, r := range relocs {
+		binary.Write(buf, le, r)
+		binary.Write(buf, le, uint32(0)) // symbol index of .rsrc
+		binary.Write(buf, le, relocType)
+	}
+
+	// symbol of .rsrc section, static
+	buf.WriteString(".rsrc\x00\x00\x00")
+	binary.Write(buf, le, uint32(0))
+	binary.Write(buf, le, int16(1))
+	binary.Write(buf, le, uint16(0))
+	buf.Write([]byte{3, 0})
+
+	// empty string table
+	binary.Write(buf, le, uint32(4))
+	return buf.Bytes()
+}
+
+// utf16z returns s in UTF-16LE with null terminator
+func utf16z(s string) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, append(utf16.Encode([]rune(s)), 0))
+	return buf.Bytes()
+}
+
+func pad4(buf *bytes.Buffer) {
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+// removeSyso removes the generated .syso file
+func removeSyso(file string) {
+	if file != "" {
+		os.Remove(file)
+	}
+}
+
+var (
+	packageDirLock  = map[string]*sync.RWMutex{} // keyed by absolute package dir
+	packageDirMutex sync.Mutex                   // guards packageDirLock
+)
+
+// lockPackageDir runs build while no .syso is in the package dir of other builds.
+// A build with .syso locks the dir exclusively, because go build links all .syso
+// files of the arch, and the untracked file marks other binaries as vcs.modified.
+func lockPackageDir(dir string, exclusive bool, build func() error) error {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	packageDirMutex.Lock()
+	lock, ok := packageDirLock[dir]
+	if !ok {
+		lock = new(sync.RWMutex)
+		packageDirLock[dir] = lock
+	}
+	packageDirMutex.Unlock()
+
+	if exclusive {
+		lock.Lock()
+		defer lock.Unlock()
+	} else {
+		lock.RLock()
+		defer lock.RUnlock()
+	}
+	return build()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// defaultAlgo is used if no --algo provided, or the algorithm of checksum file is unknown
+const defaultAlgo = "sha256"
+
+// parseAlgos returns the algorithms in comma separated list, without duplicates
+func parseAlgos(list string) ([]string, error) {
+	var algos []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if !isAlgo(name) {
+			return nil, fmt.Errorf("invalid --algo %s, should be one of: %s", name, strings.Join(algoNames(), ", "))
+		}
+		seen[name] = true
+		algos = append(algos, name)
+	}
+	if len(algos) == 0 {
+		algos = []string{defaultAlgo}
+	}
+	return algos, nil
+}
+
+// sumsFilename returns the combined checksum file of algo for --output,
+// with multiple algorithms, SHA256 in the file name is replaced by the algorithm,
+// e.g. SHA256SUMS is SHA512SUMS for sha512, or .<algo> is appended.
+func sumsFilename(output, algo string, algos []string) string {
+	if len(algos) == 1 {
+		return output
+	}
+	dir, name := filepath.Split(output)
+	if strings.Contains(name, "SHA256") {
+		return dir + strings.Replace(name, "SHA256", strings.ToUpper(algo), -1)
+	}
+	return output + "." + algo
+}
+
+// detectAlgo returns the algorithm of checksum file by its name: <file>.<algo> or <ALGO>SUMS
+func detectAlgo(filename string) (string, bool) {
+	base := strings.ToLower(filepath.Base(filename))
+	if ext := strings.TrimPrefix(filepath.Ext(base), "."); ext != "" {
+		if isAlgo(ext) {
+			return ext, true
+		}
+	}
+	for _, name := range algoNames() {
+		if strings.HasPrefix(base, name+"sums") {
+			return name, true
+		}
+	}
+	return "", false
+}
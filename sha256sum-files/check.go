@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/subchen/go-stack/fs"
+)
+
+// sumEntry is a line of checksum file
+type sumEntry struct {
+	name string // as written in checksum file
+	file string // resolved against the dir of checksum file
+	sum  string
+}
+
+// checkResult counts the outcome of verifying checksum files
+type checkResult struct {
+	verified  int
+	failed    int
+	missing   int
+	malformed int
+	invalid   int // checksum files unreadable or without properly formatted lines
+}
+
+var (
+	sumLineRegexp = regexp.MustCompile(`^([0-9a-fA-F]+) [ *](.+)$`)
+	bareSumRegexp = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+)
+
+// parseSumLine parses a line written by formatSumLine with a hex digest of size
+func parseSumLine(line string, size int) (name, sum string, ok bool) {
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+	m := sumLineRegexp.FindStringSubmatch(line)
+	if m == nil || len(m[1]) != size {
+		return "", "", false
+	}
+	name = m[2]
+	if escaped {
+		name = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(name)
+	}
+	return name, strings.ToLower(m[1]), true
+}
+
+// readSumFile returns the entries of a checksum file of algo and the number of malformed lines,
+// a .<algo> file with a bare digest refers to the file without .<algo> suffix.
+func readSumFile(filename, algo string) ([]sumEntry, int, error) {
+	size, err := digestSize(algo)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(filename)
+	var entries []sumEntry
+	malformed := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		name, sum, ok := parseSumLine(line, size)
+		if bare := strings.TrimSpace(line); !ok && strings.HasSuffix(filename, "."+algo) && len(bare) == size && bareSumRegexp.MatchString(bare) {
+			name = filepath.Base(strings.TrimSuffix(filename, "."+algo))
+			sum, ok = strings.ToLower(bare), true
+		}
+		if !ok {
+			malformed++
+			continue
+		}
+
+		file := name
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, filepath.FromSlash(name))
+		}
+		entries = append(entries, sumEntry{name: name, file: file, sum: sum})
+	}
+	return entries, malformed, scanner.Err()
+}
+
+// dirSumFiles returns the checksum files of algos in dir: .<algo>, <ALGO>SUMS and --output files
+func dirSumFiles(dir string, algos []string) []string {
+	var patterns []string
+	for _, algo := range algos {
+		patterns = append(patterns, "*."+algo, strings.ToUpper(algo)+"SUMS")
+		if outputFile != "" {
+			patterns = append(patterns, filepath.Base(sumsFilename(outputFile, algo, algos)))
+		}
+	}
+	if outputFile != "" {
+		patterns = append(patterns, filepath.Base(outputFile))
+	}
+
+	var files []string
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, file := range matches {
+			if !seen[file] && fs.IsFile(file) {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
+// checkSumFiles verifies the checksum files of algos, the algorithm of each file is
+// detected by its name unless a single algo is given. The checksum files in a dir are
+// found by dirSumFiles. The result of each entry is printed into w, and the problems
+// of checksum files into stderr.
+func checkSumFiles(sumFiles []string, algos []string, w io.Writer) checkResult {
+	var files []string
+	for _, f := range sumFiles {
+		if fs.IsDir(f) {
+			files = append(files, dirSumFiles(f, algos)...)
+		} else {
+			files = append(files, f)
+		}
+	}
+
+	var result checkResult
+	for _, filename := range files {
+		algo := algos[0]
+		if len(algos) > 1 {
+			algo = defaultAlgo
+			if name, ok := detectAlgo(filename); ok {
+				algo = name
+			}
+		}
+
+		entries, malformed, err := readSumFile(filename, algo)
+		if err != nil {
+			result.invalid++
+			fmt.Fprintf(os.Stderr, "sha256sum: %s: %v\n", filename, err)
+			continue
+		}
+		result.malformed += malformed
+		if malformed > 0 {
+			fmt.Fprintf(os.Stderr, "sha256sum: %s: %d lines are improperly formatted\n", filename, malformed)
+		}
+		if len(entries) == 0 {
+			result.invalid++
+			fmt.Fprintf(os.Stderr, "sha256sum: %s: no properly formatted checksum lines found\n", filename)
+			continue
+		}
+
+		for _, e := range entries {
+			if !fs.IsFile(e.file) {
+				if !ignoreMissing {
+					result.missing++
+					fmt.Fprintf(w, "%s: MISSING\n", e.file)
+				}
+				continue
+			}
+
+			sums, err := sumFile(e.file, algo)
+			if err != nil {
+				result.missing++
+				fmt.Fprintf(w, "%s: FAILED open or read\n", e.file)
+				continue
+			}
+			sum := sums[algo]
+			result.verified++
+			if sum != e.sum {
+				result.failed++
+				fmt.Fprintf(w, "%s: FAILED\n", e.file)
+			} else if !quiet {
+				fmt.Fprintf(w, "%s: OK\n", e.file)
+			}
+		}
+	}
+	return result
+}
+
+// err returns an error if any entry failed or missing, or any line is malformed in --strict mode
+func (r checkResult) err() error {
+	var problems []string
+	if r.failed > 0 {
+		problems = append(problems, fmt.Sprintf("%d computed checksums did NOT match", r.failed))
+	}
+	if r.missing > 0 {
+		problems = append(problems, fmt.Sprintf("%d listed files could not be read", r.missing))
+	}
+	if r.invalid > 0 {
+		problems = append(problems, fmt.Sprintf("%d checksum files could not be read or have no properly formatted lines", r.invalid))
+	}
+	if strict && r.malformed > 0 {
+		problems = append(problems, fmt.Sprintf("%d lines are improperly formatted", r.malformed))
+	}
+	if r.verified == 0 && len(problems) == 0 {
+		problems = append(problems, "no file was verified")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return nil
+}
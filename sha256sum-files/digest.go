@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// algorithms are the supported hash algorithms by lower case name
+var algorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake2b": func() hash.Hash {
+		h, _ := blake2b.New512(nil) // never fails without key
+		return h
+	},
+}
+
+// algoNames returns the names of all supported algorithms in order
+func algoNames() []string {
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isAlgo returns true if name is a supported algorithm
+func isAlgo(name string) bool {
+	_, ok := algorithms[name]
+	return ok
+}
+
+// digestSize returns the length of hex digest of algorithm
+func digestSize(algo string) (int, error) {
+	newFunc, ok := algorithms[algo]
+	if !ok {
+		return 0, fmt.Errorf("unsupported hash algorithm: %s", algo)
+	}
+	return newFunc().Size() * 2, nil
+}
+
+// sumReader returns the hex digests of all data read from r for each algorithm,
+// r is read once for all algorithms.
+func sumReader(r io.Reader, algos ...string) (map[string]string, error) {
+	hashes := make(map[string]hash.Hash, len(algos))
+	writers := make([]io.Writer, 0, len(algos))
+	for _, algo := range algos {
+		newFunc, ok := algorithms[algo]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash algorithm: %s", algo)
+		}
+		if _, ok := hashes[algo]; ok {
+			continue
+		}
+		h := newFunc()
+		hashes[algo] = h
+		writers = append(writers, h)
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]string, len(hashes))
+	for algo, h := range hashes {
+		sums[algo] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
+
+// sumFile returns the hex digests of file for each algorithm in a single pass
+func sumFile(file string, algos ...string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return sumReader(f, algos...)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/subchen/go-stack/fs"
+)
+
+// symlink modes of --symlinks
+const (
+	symlinkFiles  = "files"  // hash links to files, don't descend into links to dirs
+	symlinkFollow = "follow" // follow links to files and dirs
+	symlinkSkip   = "skip"   // ignore all links
+)
+
+// goBuildStateFile is the state file written by go-build into its output-dir, not a release file
+const goBuildStateFile = ".go-build-state.json"
+
+// collectFiles returns the files and the files in dirs to checksum,
+// checksum files, outputs, state file of go-build and duplicated files are skipped.
+func collectFiles(sourceFiles []string, outputs []string) ([]string, error) {
+	switch symlinkMode {
+	case symlinkFiles, symlinkFollow, symlinkSkip:
+	default:
+		return nil, fmt.Errorf("invalid --symlinks %s, should be files, follow or skip", symlinkMode)
+	}
+	for _, pattern := range append(append([]string(nil), includePatterns...), excludePatterns...) {
+		if err := checkGlob(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	var files []string
+	seen := map[string]bool{}
+	add := func(file string) {
+		if abs, _ := filepath.Abs(file); !seen[abs] && !isChecksumFile(file, outputs) {
+			seen[abs] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, f := range sourceFiles {
+		if fs.IsDir(f) {
+			if err := walkDir(f, "", map[string]bool{}, add); err != nil {
+				return nil, err
+			}
+		} else if fs.IsFile(f) {
+			add(f)
+		} else {
+			return nil, fmt.Errorf("file not exists: %s", f)
+		}
+	}
+	return files, nil
+}
+
+// walkDir adds the files in root/rel matching --include and --exclude,
+// rel is the slash separated path relative to root, the subdirs are walked in --recursive mode.
+// visited are the real paths of walked dirs to break symlink loops.
+func walkDir(root, rel string, visited map[string]bool, add func(string)) error {
+	dir := filepath.Join(root, filepath.FromSlash(rel))
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		if visited[real] {
+			return nil
+		}
+		visited[real] = true
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range entries {
+		name := info.Name()
+		if name == goBuildStateFile {
+			continue
+		}
+		file := filepath.Join(dir, name)
+		relPath := path.Join(rel, name)
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if symlinkMode == symlinkSkip {
+				continue
+			}
+			target, err := os.Stat(file)
+			if err != nil {
+				continue // broken link
+			}
+			if target.IsDir() && symlinkMode != symlinkFollow {
+				continue
+			}
+			info = target
+		}
+
+		if info.IsDir() {
+			if recursive && !matchAny(excludePatterns, relPath) {
+				if err := walkDir(root, relPath, visited, add); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !info.Mode().IsRegular() || matchAny(excludePatterns, relPath) {
+			continue
+		}
+		if len(includePatterns) == 0 || matchAny(includePatterns, relPath) {
+			add(file)
+		}
+	}
+	return nil
+}
+
+// isChecksumFile returns true if file is a .<algo> or <ALGO>SUMS file, or one of outputs
+func isChecksumFile(file string, outputs []string) bool {
+	if _, ok := detectAlgo(file); ok {
+		return true
+	}
+	abs, _ := filepath.Abs(file)
+	for _, output := range outputs {
+		if a, _ := filepath.Abs(output); a == abs {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny returns true if the slash separated path matches any of patterns
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob returns true if the slash separated path matches pattern,
+// `**` matches zero or more dirs, a pattern without slash matches the base name.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") && pattern != "**" {
+		return matchSegments([]string{pattern}, []string{path.Base(name)})
+	}
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(patterns, parts []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(patterns[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(patterns[0], parts[0]); !ok {
+			return false
+		}
+		patterns, parts = patterns[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// checkGlob returns an error if pattern is malformed
+func checkGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern %s: %v", pattern, err)
+		}
+	}
+	return nil
+}
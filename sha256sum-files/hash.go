@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is the refresh interval of progress display
+const progressInterval = 500 * time.Millisecond
+
+// hashResult is the checksum or error of files[index]
+type hashResult struct {
+	index int
+	checksum
+	err error
+}
+
+// countingReader counts the bytes read into n
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// hashFiles computes the digests of files using a pool of jobs workers,
+// each file is printed in order once hashed, and the checksums are returned
+// in the same order as files. The errors of all files are aggregated.
+func hashFiles(files []string, algos []string, jobs int) ([]checksum, error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > len(files) {
+		jobs = len(files)
+	}
+
+	var total, finished int64
+	start := time.Now()
+	stopProgress := startProgress(&total, &finished, len(files), start)
+
+	tasks := make(chan int)
+	results := make(chan hashResult)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range tasks {
+				sums, err := hashFile(files[j], algos, &total)
+				atomic.AddInt64(&finished, 1)
+				results <- hashResult{j, checksum{files[j], sums}, err}
+			}
+		}()
+	}
+	go func() {
+		for i := range files {
+			tasks <- i
+		}
+		close(tasks)
+		wg.Wait()
+		close(results)
+	}()
+
+	// print in order of files
+	done := make([]*hashResult, len(files))
+	checksums := make([]checksum, 0, len(files))
+	var errs []string
+	next := 0
+	for r := range results {
+		r := r
+		done[r.index] = &r
+		for next < len(files) && done[next] != nil {
+			stopProgress(false)
+			if r := done[next]; r.err != nil {
+				fmt.Fprintf(os.Stderr, "sha256sum: %s: %v\n", r.file, r.err)
+				errs = append(errs, r.file)
+			} else {
+				fmt.Printf("sha256sum: %s ...\n", r.file)
+				checksums = append(checksums, r.checksum)
+			}
+			next++
+		}
+	}
+	stopProgress(true)
+
+	elapsed := time.Since(start)
+	fmt.Printf("sha256sum: hashed %d files, %s in %s (%s/s)\n", len(files), formatBytes(total), elapsed.Round(time.Millisecond), formatBytes(rate(total, elapsed)))
+
+	if len(errs) > 0 {
+		return checksums, fmt.Errorf("%d of %d files failed: %s", len(errs), len(files), strings.Join(errs, ", "))
+	}
+	return checksums, nil
+}
+
+// hashFile computes the digests of file in a single pass, adds the bytes read into total
+func hashFile(file string, algos []string, total *int64) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return sumReader(countingReader{f, total}, algos...)
+}
+
+// startProgress refreshes the finished files, bytes and rate of hashing on stderr if it is a terminal,
+// returns a func to clear the progress line before printing, and to stop it at last.
+func startProgress(total, finished *int64, files int, start time.Time) func(stop bool) {
+	if info, err := os.Stderr.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return func(bool) {}
+	}
+
+	var lock sync.Mutex
+	quit := make(chan struct{})
+	stopped, drawn := false, false
+	clear := func() {
+		if drawn {
+			fmt.Fprint(os.Stderr, "\r\033[K")
+			drawn = false
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				lock.Lock()
+				if stopped {
+					lock.Unlock()
+					return
+				}
+				n := atomic.LoadInt64(total)
+				clear()
+				fmt.Fprintf(os.Stderr, "sha256sum: %d/%d files, %s, %s/s", atomic.LoadInt64(finished), files, formatBytes(n), formatBytes(rate(n, time.Since(start))))
+				drawn = true
+				lock.Unlock()
+			}
+		}
+	}()
+
+	return func(stop bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		if stopped {
+			return
+		}
+		clear()
+		if stop {
+			stopped = true
+			close(quit)
+		}
+	}
+}
+
+// rate returns the bytes per second
+func rate(n int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(n) / elapsed.Seconds())
+}
+
+// formatBytes returns n in human readable units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
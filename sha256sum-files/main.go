@@ -2,14 +2,10 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
-	"strings"
+	"sort"
 
 	"github.com/subchen/go-cli"
-	"github.com/subchen/go-stack/encoding/sha256"
-	"github.com/subchen/go-stack/fs"
 	"github.com/subchen/go-stack/runs"
 )
 
@@ -21,33 +17,165 @@ var (
 	buildDate      string
 )
 
+var (
+	manifestFile string
+	outputFile   string
+	binaryMode   bool
+	algoList     string
+
+	recursive       bool
+	includePatterns []string
+	excludePatterns []string
+	symlinkMode     string
+	jobs            int
+
+	checkMode     bool
+	quiet         bool
+	strict        bool
+	ignoreMissing bool
+)
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "sha256sum-files"
-	app.Usage = "Generates SHA256 (256-bit) checksum files"
+	app.Usage = "Generates SHA256 (256-bit) or other checksum files"
 	app.Authors = "Guoqiang Chen <subchen@gmail.com>"
-	app.UsageText = " <dir|file> ..."
+	app.UsageText = " [OPTIONS...] <dir|file> ..."
+
+	app.Flags = []*cli.Flag{
+		{
+			Name:  "manifest",
+			Usage: "generate checksum files for the artifacts in manifest.json of go-build",
+			Value: &manifestFile,
+		},
+		{
+			Name:        "a, algo",
+			Usage:       "comma separated hash algorithms: sha256, sha512, sha1, md5, blake2b",
+			Placeholder: "list",
+			Value:       &algoList,
+			DefValue:    defaultAlgo,
+		},
+		{
+			Name:  "r, recursive",
+			Usage: "checksum the files in subdirs of dirs",
+			Value: &recursive,
+		},
+		{
+			Name:        "include",
+			Usage:       "checksum only the files in dirs matching glob pattern, `**` matches any dirs, can be repeated",
+			Placeholder: "pattern",
+			Value:       &includePatterns,
+		},
+		{
+			Name:        "exclude",
+			Usage:       "skip the files and subdirs in dirs matching glob pattern, `**` matches any dirs, can be repeated",
+			Placeholder: "pattern",
+			Value:       &excludePatterns,
+		},
+		{
+			Name:        "symlinks",
+			Usage:       "symlinks in dirs: files (follow links to files only), follow, skip",
+			Placeholder: "mode",
+			Value:       &symlinkMode,
+			DefValue:    symlinkFiles,
+		},
+		{
+			Name:     "j, jobs",
+			Usage:    "number of files to hash in parallel",
+			Value:    &jobs,
+			DefValue: "1",
+		},
+		{
+			Name:        "o, output",
+			Usage:       "write all checksums into a combined file, e.g. SHA256SUMS, instead of <file>.<algo>",
+			Placeholder: "file",
+			Value:       &outputFile,
+		},
+		{
+			Name:  "b, binary",
+			Usage: "mark files with `*` as binary mode in checksum files",
+			Value: &binaryMode,
+		},
+		{
+			Name:  "c, check",
+			Usage: "verify the files listed in checksum files, <file>.<algo> or combined file",
+			Value: &checkMode,
+		},
+		{
+			Name:  "q, quiet",
+			Usage: "don't print OK for each successfully verified file",
+			Value: &quiet,
+		},
+		{
+			Name:  "strict",
+			Usage: "exit non-zero for improperly formatted checksum lines",
+			Value: &strict,
+		},
+		{
+			Name:  "ignore-missing",
+			Usage: "don't fail or report status for missing files",
+			Value: &ignoreMissing,
+		},
+	}
 
 	app.Action = func(c *cli.Context) {
-		if c.NArg() == 0 {
+		if c.NArg() == 0 && manifestFile == "" {
 			c.ShowHelpAndExit(0)
 		}
 
-		for _, f := range c.Args() {
-			if fs.IsDir(f) {
-				files, err := ioutil.ReadDir(f)
-				runs.PanicIfErr(err)
+		algos, err := parseAlgos(algoList)
+		runs.PanicIfErr(err)
 
-				for _, file := range files {
-					sha256sum(filepath.Join(f, file.Name()))
-				}
-			} else if fs.IsFile(f) {
-				sha256sum(f)
-			} else {
-				panic("file not exists: " + f)
+		if checkMode {
+			if !c.IsSet("algo") {
+				algos = algoNames() // detected by checksum file name
 			}
+			result := checkSumFiles(c.Args(), algos, os.Stdout)
+			if err := result.err(); err != nil {
+				fmt.Fprintf(os.Stderr, "sha256sum: WARNING: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		sourceFiles := c.Args()
+		if manifestFile != "" {
+			files, err := readManifest(manifestFile)
+			runs.PanicIfErr(err)
+			sourceFiles = append(files, sourceFiles...)
 		}
 
+		var outputs []string
+		if outputFile != "" {
+			for _, algo := range algos {
+				outputs = append(outputs, sumsFilename(outputFile, algo, algos))
+			}
+		}
+
+		files, err := collectFiles(sourceFiles, outputs)
+		runs.PanicIfErr(err)
+		sort.Strings(files)
+
+		// write the checksums of hashed files, even if some files failed
+		checksums, hashErr := hashFiles(files, algos, jobs)
+
+		for i, algo := range algos {
+			if outputFile != "" {
+				err := writeSumsFile(outputs[i], algo, checksums, binaryMode)
+				runs.PanicIfErr(err)
+				fmt.Printf("sha256sum: written %s\n", outputs[i])
+				continue
+			}
+			for _, c := range checksums {
+				err := writeSumFile(c, algo, binaryMode)
+				runs.PanicIfErr(err)
+			}
+		}
+
+		if hashErr != nil {
+			fmt.Fprintf(os.Stderr, "sha256sum: WARNING: %v\n", hashErr)
+			os.Exit(1)
+		}
 		fmt.Println("sha256sum: Completed!")
 	}
 
@@ -59,17 +187,3 @@ func main() {
 
 	app.Run(os.Args)
 }
-
-func sha256sum(file string) {
-	if strings.HasSuffix(file, ".sha256") {
-		return
-	}
-
-	if !fs.IsFile(file) {
-		return
-	}
-
-	fmt.Printf("sha256sum: %s ...\n", file)
-	err := sha256.GenerateSumFile(file)
-	runs.PanicIfErr(err)
-}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// manifest is the manifest.json written by go-build
+type manifest struct {
+	Artifacts []struct {
+		Path string `json:"path"` // relative to manifest dir
+	} `json:"artifacts"`
+}
+
+// readManifest returns the artifact files listed in manifest
+func readManifest(filename string) ([]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(filename)
+	files := make([]string, 0, len(m.Artifacts))
+	for _, a := range m.Artifacts {
+		files = append(files, filepath.Join(dir, filepath.FromSlash(a.Path)))
+	}
+	return files, nil
+}
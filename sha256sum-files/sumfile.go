@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checksum is the digests of a file, keyed by algorithm
+type checksum struct {
+	file string
+	sums map[string]string
+}
+
+// formatSumLine returns a line in the format of coreutils sha256sum: `<hex>  <name>`,
+// or `<hex> *<name>` in binary mode. A name containing backslash or newline is
+// escaped, and the line is prefixed with a backslash.
+func formatSumLine(sum, name string, binary bool) string {
+	prefix := ""
+	if strings.ContainsAny(name, "\\\n") {
+		prefix = "\\"
+		name = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+	}
+	marker := " "
+	if binary {
+		marker = "*"
+	}
+	return fmt.Sprintf("%s%s %s%s\n", prefix, sum, marker, name)
+}
+
+// writeSumFile writes <file>.<algo> for a file with its base name
+func writeSumFile(c checksum, algo string, binary bool) error {
+	line := formatSumLine(c.sums[algo], filepath.Base(c.file), binary)
+	return ioutil.WriteFile(c.file+"."+algo, []byte(line), 0644)
+}
+
+// writeSumsFile writes a combined checksums file, the names are relative to
+// the dir of the output file and sorted, so that it can be verified by `sha256sum -c` etc.
+func writeSumsFile(output, algo string, checksums []checksum, binary bool) error {
+	dir, err := filepath.Abs(filepath.Dir(output))
+	if err != nil {
+		return err
+	}
+
+	lines := make([]struct{ name, sum string }, 0, len(checksums))
+	for _, c := range checksums {
+		file, err := filepath.Abs(c.file)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, struct{ name, sum string }{filepath.ToSlash(name), c.sums[algo]})
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].name < lines[j].name
+	})
+
+	var sb strings.Builder
+	for _, l := range lines {
+		sb.WriteString(formatSumLine(l.sum, l.name, binary))
+	}
+	return ioutil.WriteFile(output, []byte(sb.String()), 0644)
+}